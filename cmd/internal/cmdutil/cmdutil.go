@@ -0,0 +1,112 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmdutil holds the helpers shared by the lynkstor-dump,
+// lynkstor-restore and lynkstor-diff commands.
+package cmdutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+// key_len_max is longer than any key the server accepts.
+const key_len_max = 1024
+
+// EntryDecode splits a stored entry into the expiry time of its meta and
+// the value.
+func EntryDecode(data []byte) (uint64, []byte) {
+	meta, value := lynkstor.RepEntryDecode(data)
+	if meta != nil {
+		return meta.Expired, value
+	}
+	return 0, value
+}
+
+// KeyEnd returns the first key after all keys that start with prefix, the
+// cutset of a scan of prefix. A prefix without such a key, as the empty
+// one, ends with a run of 0xff longer than any key.
+func KeyEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return append(end, bytes.Repeat([]byte{0xff}, key_len_max)...)
+}
+
+// ProgKey parses /a/b/c into the prog key [a, b, c].
+func ProgKey(path string) skv.KvProgKey {
+	k := skv.KvProgKey{}
+	for _, v := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		k.Append(v)
+	}
+	return k
+}
+
+// ProgChild returns the scan prefix of the level below the key k, [k..., ""].
+func ProgChild(k *skv.KvProgKey) skv.KvProgKey {
+	c := skv.KvProgKey{}
+	for _, v := range k.Items {
+		c.Append(v.Data)
+	}
+	c.Append("")
+	return c
+}
+
+// ProgKeyString returns the path form /a/b/c of a prog key.
+func ProgKeyString(k *skv.KvProgKey) string {
+	s := ""
+	for _, v := range k.Items {
+		s += "/" + string(v.Data)
+	}
+	return s
+}
+
+func SplitList(s string) []string {
+	ls := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ls = append(ls, v)
+		}
+	}
+	return ls
+}
+
+func Has(ls []string, s string) bool {
+	for _, v := range ls {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Exit prints the message to stderr and exits with code.
+func Exit(code int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(code)
+}
+
+func Fatal(format string, args ...interface{}) {
+	Exit(1, format, args...)
+}
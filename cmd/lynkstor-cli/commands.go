@@ -0,0 +1,257 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/cmd/internal/cmdutil"
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+func init() {
+
+	commands["kvget"] = &command{"key", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_get(conn.KvGet([]byte(args[0])))
+	}}
+
+	commands["kvput"] = &command{"key value [ttl_ms]", 2, func(conn *lynkstor.Connector, args []string) error {
+		ttl, err := int_arg(args, 2, 0)
+		if err != nil {
+			return err
+		}
+		return print_status(conn.KvPut([]byte(args[0]), args[1], &skv.KvWriteOptions{Ttl: ttl}))
+	}}
+
+	commands["kvdel"] = &command{"key [key...]", 1, func(conn *lynkstor.Connector, args []string) error {
+		keys := [][]byte{}
+		for _, v := range args {
+			keys = append(keys, []byte(v))
+		}
+		return print_status(conn.KvDel(keys...))
+	}}
+
+	commands["kvscan"] = &command{"offset cutset [limit]", 2, func(conn *lynkstor.Connector, args []string) error {
+		limit, err := int_arg(args, 2, 100)
+		if err != nil {
+			return err
+		}
+		return print_scan(conn.KvScan([]byte(args[0]), []byte(args[1]), int(limit)), false)
+	}}
+
+	commands["kvincr"] = &command{"key [increment]", 1, func(conn *lynkstor.Connector, args []string) error {
+		n, err := int_arg(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		return print_get(conn.KvIncr([]byte(args[0]), n))
+	}}
+
+	commands["kvmeta"] = &command{"key", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_meta(args[0], conn.KvMeta([]byte(args[0])))
+	}}
+
+	commands["progget"] = &command{"/a/b/c", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_get(conn.KvProgGet(cmdutil.ProgKey(args[0])))
+	}}
+
+	commands["progput"] = &command{"/a/b/c value", 2, func(conn *lynkstor.Connector, args []string) error {
+		return print_status(conn.KvProgPut(cmdutil.ProgKey(args[0]), skv.NewKvEntry(args[1]), nil))
+	}}
+
+	commands["progdel"] = &command{"/a/b/c", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_status(conn.KvProgDel(cmdutil.ProgKey(args[0]), nil))
+	}}
+
+	commands["progscan"] = &command{"/a/b/ [limit]", 1, func(conn *lynkstor.Connector, args []string) error {
+		limit, err := int_arg(args, 1, 100)
+		if err != nil {
+			return err
+		}
+		k := cmdutil.ProgKey(args[0])
+		return print_scan(conn.KvProgScan(k, k, int(limit)), true)
+	}}
+
+	commands["progincr"] = &command{"/a/b/c [increment]", 1, func(conn *lynkstor.Connector, args []string) error {
+		n, err := int_arg(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		return print_get(conn.KvProgIncr(cmdutil.ProgKey(args[0]), n))
+	}}
+
+	commands["progmeta"] = &command{"/a/b/c", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_meta(args[0], conn.KvProgMeta(cmdutil.ProgKey(args[0])))
+	}}
+
+	commands["pvget"] = &command{"path", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_get(conn.PvGet(args[0]))
+	}}
+
+	commands["pvput"] = &command{"path value", 2, func(conn *lynkstor.Connector, args []string) error {
+		return print_status(conn.PvPut(args[0], args[1], nil))
+	}}
+
+	commands["pvdel"] = &command{"path", 1, func(conn *lynkstor.Connector, args []string) error {
+		return print_status(conn.PvDel(args[0], nil))
+	}}
+
+	commands["pvscan"] = &command{"fold [offset cutset [limit]]", 1, func(conn *lynkstor.Connector, args []string) error {
+		offset, cutset := "", ""
+		if len(args) > 2 {
+			offset, cutset = args[1], args[2]
+		}
+		limit, err := int_arg(args, 3, 100)
+		if err != nil {
+			return err
+		}
+		return print_scan(conn.PvScan(args[0], offset, cutset, int(limit)), true)
+	}}
+}
+
+// value_of returns the json document of a value, or its string if the
+// value is not json.
+func value_of(rs skv.Result) interface{} {
+	var v interface{}
+	if err := rs.Decode(&v); err == nil {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return v
+		}
+	}
+	return rs.String()
+}
+
+func print_json(v interface{}) {
+	bs, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(bs))
+}
+
+func print_value(key string, rs skv.Result) {
+
+	if *flag_raw {
+		if key != "" {
+			fmt.Println(key)
+		}
+		os.Stdout.Write(rs.Bytex())
+		fmt.Println()
+		return
+	}
+
+	if *flag_json {
+		obj := map[string]interface{}{
+			"value": value_of(rs),
+		}
+		if key != "" {
+			obj["key"] = key
+		}
+		if meta := rs.Meta(); meta != nil {
+			obj["meta"] = meta
+		}
+		print_json(obj)
+		return
+	}
+
+	if key != "" {
+		fmt.Printf("%s:\n", key)
+	}
+	if v := value_of(rs); v != nil {
+		if s, ok := v.(string); ok {
+			fmt.Println(s)
+		} else {
+			print_json(v)
+		}
+	}
+}
+
+func print_get(rs skv.Result) error {
+	if !rs.OK() {
+		return result_error(rs)
+	}
+	print_value("", rs)
+	return nil
+}
+
+func print_status(rs skv.Result) error {
+	if !rs.OK() {
+		return result_error(rs)
+	}
+	if *flag_json {
+		print_json(map[string]interface{}{"ok": true})
+	} else {
+		fmt.Println("OK")
+	}
+	return nil
+}
+
+func print_meta(key string, rs skv.Result) error {
+	if !rs.OK() {
+		return result_error(rs)
+	}
+	meta := rs.Meta()
+	if meta == nil {
+		return fmt.Errorf("no meta found of %s", key)
+	}
+	print_json(meta)
+	return nil
+}
+
+func print_scan(rs skv.Result, prog bool) error {
+
+	if rs.NotFound() {
+		if *flag_json {
+			fmt.Println("[]")
+		}
+		return nil
+	} else if !rs.OK() {
+		return result_error(rs)
+	}
+
+	if *flag_json {
+		ls := []interface{}{}
+		for _, v := range rs.KvPairs() {
+			obj := map[string]interface{}{
+				"key":   scan_key(v.KvKey(), prog),
+				"value": value_of(v),
+			}
+			if meta := v.Meta(); meta != nil {
+				obj["meta"] = meta
+			}
+			ls = append(ls, obj)
+		}
+		print_json(ls)
+		return nil
+	}
+
+	for _, v := range rs.KvPairs() {
+		print_value(scan_key(v.KvKey(), prog), v)
+	}
+
+	return nil
+}
+
+func scan_key(key []byte, prog bool) string {
+	if prog {
+		if k := skv.ProgKeyDecode(key); k != nil {
+			return cmdutil.ProgKeyString(k)
+		}
+		return fmt.Sprintf("%q", key)
+	}
+	return string(key)
+}
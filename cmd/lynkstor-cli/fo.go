@@ -0,0 +1,519 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+var (
+	fo_scan_limit = 1000
+)
+
+func init() {
+
+	commands["put"] = &command{"[-r] local... remote", 2, func(conn *lynkstor.Connector, args []string) error {
+		recursive, args := arg_flag(args, "-r")
+		if len(args) < 2 {
+			return errors.New("usage: put [-r] local... remote")
+		}
+		return fo_put(conn, args[:len(args)-1], args[len(args)-1], recursive)
+	}}
+
+	commands["get"] = &command{"remote [local]", 1, func(conn *lynkstor.Connector, args []string) error {
+		dst := "."
+		if len(args) > 1 {
+			dst = args[1]
+		}
+		return fo_get(conn, args[0], dst)
+	}}
+
+	commands["ls"] = &command{"remote_dir", 1, func(conn *lynkstor.Connector, args []string) error {
+		ls, err := fo_list(conn, args[0])
+		if err != nil {
+			return err
+		}
+		if *flag_json {
+			print_json(ls)
+			return nil
+		}
+		for _, v := range ls {
+			fmt.Printf("%12d  %s\n", v.Size, v.Path)
+		}
+		return nil
+	}}
+
+	commands["stat"] = &command{"remote", 1, func(conn *lynkstor.Connector, args []string) error {
+		rs := conn.FoGet(args[0])
+		if !rs.OK() {
+			return result_error(rs)
+		}
+		var fo_meta skv.FileObjectEntryMeta
+		if err := rs.Decode(&fo_meta); err != nil {
+			return err
+		}
+		print_json(map[string]interface{}{
+			"path":   args[0],
+			"object": fo_meta,
+			"meta":   rs.Meta(),
+		})
+		return nil
+	}}
+
+	commands["rm"] = &command{"remote...", 1, func(conn *lynkstor.Connector, args []string) error {
+		for _, v := range args {
+			paths, err := fo_match(conn, v)
+			if err != nil {
+				return err
+			}
+			for _, p := range paths {
+				if rs := conn.FoDel(p); !rs.OK() {
+					return fmt.Errorf("rm %s: %s", p, result_error(rs))
+				}
+				fmt.Println("removed", p)
+			}
+		}
+		return nil
+	}}
+
+	commands["sync"] = &command{"local_dir remote_dir", 2, func(conn *lynkstor.Connector, args []string) error {
+		return fo_sync(conn, args[0], args[1])
+	}}
+}
+
+type foEntry struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+// arg_flag removes the flag name from args and reports whether it was set.
+func arg_flag(args []string, name string) (bool, []string) {
+	set, ls := false, []string{}
+	for _, v := range args {
+		if v == name {
+			set = true
+		} else {
+			ls = append(ls, v)
+		}
+	}
+	return set, ls
+}
+
+func has_glob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+func fo_put(conn *lynkstor.Connector, srcs []string, dst string, recursive bool) error {
+
+	files := [][2]string{}
+
+	for _, src := range srcs {
+
+		matches, err := filepath.Glob(src)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return errors.New("no such file: " + src)
+		}
+
+		for _, m := range matches {
+
+			st, err := os.Stat(m)
+			if err != nil {
+				return err
+			}
+
+			if !st.IsDir() {
+				target := dst
+				if len(srcs) > 1 || len(matches) > 1 || strings.HasSuffix(dst, "/") {
+					target = path.Join(dst, filepath.Base(m))
+				}
+				files = append(files, [2]string{m, target})
+				continue
+			}
+
+			if !recursive {
+				fmt.Fprintf(os.Stderr, "skip directory %s, use -r\n", m)
+				continue
+			}
+
+			root := path.Join(dst, filepath.Base(m))
+			err = filepath.Walk(m, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				if info.Size() < 1 {
+					fmt.Fprintf(os.Stderr, "skip empty file %s\n", p)
+					return nil
+				}
+				rel, err := filepath.Rel(m, p)
+				if err != nil {
+					return err
+				}
+				files = append(files, [2]string{p, path.Join(root, filepath.ToSlash(rel))})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, v := range files {
+		if put, err := fo_upload(conn, v[0], v[1]); err != nil {
+			return err
+		} else if !put {
+			fmt.Fprintf(os.Stderr, "unchanged %s\n", v[1])
+		}
+	}
+
+	return nil
+}
+
+// fo_upload uploads src to dst unless dst holds the same data already, and
+// reports whether it did. An upload resumes onto an object of the same
+// size, so a changed file replaces an existing object.
+func fo_upload(conn *lynkstor.Connector, src, dst string) (bool, error) {
+
+	st, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+
+	rs := conn.FoGet(dst)
+	if rs.NotFound() {
+		return true, fo_put_file(conn, src, dst)
+	} else if !rs.OK() {
+		return false, fmt.Errorf("%s: %s", dst, result_error(rs))
+	}
+
+	var fo_meta skv.FileObjectEntryMeta
+	if err := rs.Decode(&fo_meta); err != nil {
+		return false, fmt.Errorf("%s: %s", dst, err)
+	}
+
+	if fo_meta.Size == uint64(st.Size()) {
+		if size, sums, err := conn.FoBlockSums(dst); err == nil && size == fo_meta.Size {
+			if local, err := file_block_sums(src); err == nil && sums_equal(local, sums) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, fo_replace_file(conn, src, dst)
+}
+
+func fo_put_file(conn *lynkstor.Connector, src, dst string) error {
+	rs := conn.FoFilePutProgress(src, dst, progress_bar(dst))
+	if !rs.OK() {
+		return fmt.Errorf("put %s: %s", src, result_error(rs))
+	}
+	return nil
+}
+
+func fo_get(conn *lynkstor.Connector, src, dst string) error {
+
+	paths, err := fo_match(conn, src)
+	if err != nil {
+		return err
+	}
+
+	st, err := os.Stat(dst)
+	is_dir := err == nil && st.IsDir()
+	if len(paths) > 1 && !is_dir {
+		return errors.New("target must be a directory: " + dst)
+	}
+
+	for _, p := range paths {
+
+		target := dst
+		if is_dir {
+			target = filepath.Join(dst, path.Base(p))
+		}
+
+		if err := fo_get_file(conn, p, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fo_get_file(conn *lynkstor.Connector, src, dst string) error {
+
+	rs := conn.FoGet(src)
+	if !rs.OK() {
+		return fmt.Errorf("get %s: %s", src, result_error(rs))
+	}
+	var fo_meta skv.FileObjectEntryMeta
+	if err := rs.Decode(&fo_meta); err != nil {
+		return err
+	}
+
+	r, err := conn.FoFileOpen(src)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var (
+		progress = progress_bar(src)
+		buf      = make([]byte, 1024*1024)
+		done     = int64(0)
+	)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := fp.Write(buf[:n]); err != nil {
+				return err
+			}
+			done += int64(n)
+			progress(done, int64(fo_meta.Size))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fo_match returns the remote path, or the paths in its directory that
+// match its base name if it holds a glob pattern.
+func fo_match(conn *lynkstor.Connector, remote string) ([]string, error) {
+
+	if !has_glob(path.Base(remote)) {
+		return []string{remote}, nil
+	}
+
+	ls, err := fo_list(conn, path.Dir(remote))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, v := range ls {
+		if ok, _ := path.Match(path.Base(remote), path.Base(v.Path)); ok {
+			paths = append(paths, v.Path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("no such object: " + remote)
+	}
+
+	return paths, nil
+}
+
+func fo_list(conn *lynkstor.Connector, dir string) ([]*foEntry, error) {
+
+	var (
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+		offset = prefix
+		ls     = []*foEntry{}
+	)
+
+	for {
+
+		rs := conn.FoScan(offset, prefix, fo_scan_limit)
+		if rs.NotFound() {
+			return ls, nil
+		} else if !rs.OK() {
+			return nil, result_error(rs)
+		}
+
+		items := rs.KvPairs()
+		for _, v := range items {
+			var fo_meta skv.FileObjectEntryMeta
+			if err := v.Decode(&fo_meta); err != nil {
+				continue
+			}
+			p := fo_meta.Path
+			if p == "" {
+				p = string(v.KvKey())
+			}
+			if p == offset {
+				continue
+			}
+			ls = append(ls, &foEntry{
+				Path: p,
+				Size: fo_meta.Size,
+			})
+			offset = p
+		}
+
+		if len(items) < fo_scan_limit {
+			return ls, nil
+		}
+	}
+}
+
+// fo_sync uploads the files of a local directory whose size or block
+// checksums differ from the remote objects.
+func fo_sync(conn *lynkstor.Connector, src, dst string) error {
+
+	num_put, num_skip := 0, 0
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+
+		if err != nil || info.IsDir() || info.Size() < 1 {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		put, err := fo_upload(conn, p, path.Join(dst, filepath.ToSlash(rel)))
+		if put {
+			num_put++
+		} else if err == nil {
+			num_skip++
+		}
+		return err
+	})
+
+	fmt.Printf("sync done, %d uploaded, %d unchanged\n", num_put, num_skip)
+
+	return err
+}
+
+// fo_replace_file replaces an existing object. Objects can not be renamed,
+// so the file is uploaded twice: first to a temporary object, which keeps
+// the new version if the final upload fails, then to dst once the old
+// object is deleted. dst does not exist during the second upload.
+func fo_replace_file(conn *lynkstor.Connector, src, dst string) error {
+
+	tmp := dst + ".sync~"
+
+	if rs := conn.FoDel(tmp); !rs.OK() && !rs.NotFound() {
+		return fmt.Errorf("rm %s: %s", tmp, result_error(rs))
+	}
+	if err := fo_put_file(conn, src, tmp); err != nil {
+		conn.FoDel(tmp)
+		return err
+	}
+
+	if rs := conn.FoDel(dst); !rs.OK() && !rs.NotFound() {
+		return fmt.Errorf("rm %s: %s, the new version is kept as %s", dst, result_error(rs), tmp)
+	}
+	if err := fo_put_file(conn, src, dst); err != nil {
+		return fmt.Errorf("%s, the new version is kept as %s", err, tmp)
+	}
+
+	if rs := conn.FoDel(tmp); !rs.OK() && !rs.NotFound() {
+		return fmt.Errorf("rm %s: %s", tmp, result_error(rs))
+	}
+	return nil
+}
+
+func file_block_sums(name string) ([]uint32, error) {
+
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var (
+		sums = []uint32{}
+		buf  = make([]byte, skv.FileObjectBlockSize4)
+	)
+
+	for {
+		n, err := io.ReadFull(fp, buf)
+		if n > 0 {
+			sums = append(sums, crc32.ChecksumIEEE(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sums, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sums_equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// progress_bar returns a progress callback that draws a bar on stderr.
+func progress_bar(name string) func(done, total int64) {
+
+	last := time.Time{}
+
+	return func(done, total int64) {
+
+		if done < total && time.Since(last) < 200*time.Millisecond {
+			return
+		}
+		last = time.Now()
+
+		pct := 100
+		if total > 0 {
+			pct = int(done * 100 / total)
+		}
+
+		bar := strings.Repeat("=", pct/5) + strings.Repeat(" ", 20-pct/5)
+		fmt.Fprintf(os.Stderr, "\r[%s] %3d%% %s/%s %s", bar, pct,
+			size_string(done), size_string(total), name)
+
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func size_string(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
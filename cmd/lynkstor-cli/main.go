@@ -0,0 +1,212 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lynkstor-cli is a command line client of lynkstor.
+//
+//	lynkstor-cli [flags]                  start the interactive shell
+//	lynkstor-cli [flags] command args...  run one command and exit
+//
+// Prog keys are written as paths, /a/b/c is the key [a, b, c], and a
+// trailing slash such as /a/b/ selects all keys under [a, b] in scans.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+var (
+	flag_host   = flag.String("host", "127.0.0.1", "server host")
+	flag_port   = flag.Int("port", 6378, "server port")
+	flag_auth   = flag.String("auth", "", "password for authentication")
+	flag_socket = flag.String("socket", "", "path of a unix socket, instead of host and port")
+	flag_raw    = flag.Bool("raw", false, "print values as raw bytes")
+	flag_json   = flag.Bool("json", false, "print results as json objects")
+)
+
+type command struct {
+	usage string
+	min   int
+	fn    func(conn *lynkstor.Connector, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func main() {
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lynkstor-cli [flags] [command args...]\n\nflags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+		cmd_help(os.Stderr)
+	}
+	flag.Parse()
+
+	conn, err := lynkstor.NewConnector(lynkstor.Config{
+		Host:    *flag_host,
+		Port:    uint16(*flag_port),
+		Auth:    *flag_auth,
+		Socket:  *flag_socket,
+		MaxConn: 1,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connect:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if flag.NArg() > 0 {
+		if err := run(conn, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	repl(conn, os.Stdin)
+}
+
+func repl(conn *lynkstor.Connector, in io.Reader) {
+
+	sc := bufio.NewScanner(in)
+	prompt := fmt.Sprintf("lynkstor %s:%d> ", *flag_host, *flag_port)
+	if *flag_socket != "" {
+		prompt = fmt.Sprintf("lynkstor %s> ", *flag_socket)
+	}
+
+	for {
+		fmt.Print(prompt)
+		if !sc.Scan() {
+			fmt.Println()
+			return
+		}
+
+		args, err := split_args(sc.Text())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch args[0] {
+		case "exit", "quit":
+			return
+		}
+
+		if err := run(conn, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func run(conn *lynkstor.Connector, args []string) error {
+
+	if args[0] == "help" {
+		cmd_help(os.Stdout)
+		return nil
+	}
+
+	cmd, ok := commands[strings.ToLower(args[0])]
+	if !ok {
+		return errors.New("unknown command " + args[0] + ", try help")
+	}
+
+	if len(args)-1 < cmd.min {
+		return errors.New("usage: " + args[0] + " " + cmd.usage)
+	}
+
+	return cmd.fn(conn, args[1:])
+}
+
+func cmd_help(w io.Writer) {
+	names := []string{}
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "commands:")
+	for _, k := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", k, commands[k].usage)
+	}
+	fmt.Fprintf(w, "  %-10s\n  %-10s\n", "help", "exit")
+}
+
+// split_args splits a line at spaces, single or double quotes group words.
+func split_args(line string) ([]string, error) {
+
+	var (
+		args  []string
+		cur   strings.Builder
+		quote rune
+		has   bool
+	)
+
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, has = c, true
+		case c == ' ' || c == '\t':
+			if has {
+				args = append(args, cur.String())
+				cur.Reset()
+				has = false
+			}
+		default:
+			cur.WriteRune(c)
+			has = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if has {
+		args = append(args, cur.String())
+	}
+
+	return args, nil
+}
+
+func int_arg(args []string, i int, def int64) (int64, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	return strconv.ParseInt(args[i], 10, 64)
+}
+
+func result_error(rs skv.Result) error {
+	if rs.NotFound() {
+		return errors.New("(not found)")
+	}
+	return errors.New(rs.ErrorString())
+}
@@ -0,0 +1,424 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lynkstor-diff compares the data of two servers, a the source and b the
+// copy, and prints the keys missing in b, extra in b, or different.
+//
+//	lynkstor-diff [flags] -a host:port -b host:port
+//
+// Raw keys are split into --parallel key ranges, prog key paths and file
+// object folders are compared in parallel, and every prog level below a key
+// and every subfolder becomes a task of its own. Values are compared as
+// stored, file objects by size and then by the block checksums recorded at
+// upload, read from the blocks only where no record matches. The records
+// themselves, prog keys under _fo_sums, are not compared. --repair writes
+// a shell script and an archive that make b equal to a, --apply repairs b
+// at once, otherwise neither server is written to.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/cmd/internal/cmdutil"
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+var (
+	flag_a        = flag.String("a", "127.0.0.1:6378", "address of the source server")
+	flag_a_auth   = flag.String("a_auth", "", "password of the source server")
+	flag_b        = flag.String("b", "", "address of the server to compare")
+	flag_b_auth   = flag.String("b_auth", "", "password of the server to compare")
+	flag_kv       = flag.String("kv", "", "comma separated prefixes of raw keys, empty for all keys")
+	flag_prog     = flag.String("prog", "/", "comma separated prog key paths, such as /a/b/")
+	flag_fo       = flag.String("fo", "/", "comma separated file object folders")
+	flag_skip     = flag.String("skip", "", "comma separated kinds to skip: kv, prog, fo")
+	flag_parallel = flag.Int("parallel", 4, "number of ranges compared at once")
+	flag_limit    = flag.Int("limit", 1000, "number of keys per scan")
+	flag_slack    = flag.Duration("ttl_slack", 5*time.Second, "allowed difference of expiry times")
+	flag_repair   = flag.String("repair", "", "write a repair script to this file, and its archive to file.lkd")
+	flag_apply    = flag.Bool("apply", false, "repair the server b")
+	flag_quiet    = flag.Bool("quiet", false, "print the summary only")
+)
+
+// task is one range of keys compared by a worker.
+type task struct {
+	kind string
+	from []byte // kv
+	to   []byte // kv
+	prog skv.KvProgKey
+	fo   string
+}
+
+type differ struct {
+	a, b    *lynkstor.Connector
+	mu      sync.Mutex
+	num     map[string]int
+	repair  *repairer
+	cond    *sync.Cond
+	queue   []*task
+	running int
+}
+
+func main() {
+
+	flag.Parse()
+
+	if *flag_b == "" {
+		cmdutil.Exit(2, "no -b found")
+	}
+	if *flag_parallel < 1 {
+		*flag_parallel = 1
+	} else if *flag_parallel > 64 {
+		*flag_parallel = 64
+	}
+
+	a, err := connect(*flag_a, *flag_a_auth)
+	if err != nil {
+		cmdutil.Exit(2, "connect %s: %s", *flag_a, err)
+	}
+	defer a.Close()
+
+	b, err := connect(*flag_b, *flag_b_auth)
+	if err != nil {
+		cmdutil.Exit(2, "connect %s: %s", *flag_b, err)
+	}
+	defer b.Close()
+
+	d := &differ{
+		a:   a,
+		b:   b,
+		num: map[string]int{},
+	}
+	d.cond = sync.NewCond(&d.mu)
+
+	if *flag_repair != "" {
+		if d.repair, err = newRepairer(*flag_repair, a); err != nil {
+			cmdutil.Exit(2, "%s", err)
+		}
+	}
+
+	for _, t := range d.tasks() {
+		d.run(t)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *flag_parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work()
+		}()
+	}
+	wg.Wait()
+
+	if d.repair != nil {
+		if err := d.repair.close(); err != nil {
+			cmdutil.Exit(2, "%s", err)
+		}
+	}
+
+	fmt.Printf("diff done, same %d, missing %d, extra %d, differ %d, errors %d",
+		d.num["same"], d.num["missing"], d.num["extra"], d.num["differ"], d.num["error"])
+	if *flag_apply {
+		fmt.Printf(", repaired %d, repair errors %d", d.num["repaired"], d.num["repair_error"])
+	}
+	fmt.Println()
+
+	if d.num["error"] > 0 {
+		os.Exit(2)
+	}
+	if d.num["missing"]+d.num["extra"]+d.num["differ"] > d.num["repaired"] {
+		os.Exit(1)
+	}
+}
+
+func connect(addr, auth string) (*lynkstor.Connector, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	return lynkstor.NewConnector(lynkstor.Config{
+		Host:    host,
+		Port:    uint16(n),
+		Auth:    auth,
+		MaxConn: *flag_parallel,
+	})
+}
+
+func (d *differ) tasks() []*task {
+
+	var (
+		tasks = []*task{}
+		skip  = cmdutil.SplitList(*flag_skip)
+	)
+
+	if !cmdutil.Has(skip, kind_kv) {
+		prefixes := cmdutil.SplitList(*flag_kv)
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		// split every prefix by the byte that follows it
+		for _, v := range prefixes {
+			prefix, last := []byte(v), []byte(nil)
+			for i := 0; i < *flag_parallel; i++ {
+				from := last
+				if from == nil {
+					from = prefix
+				}
+				to := append(append([]byte{}, prefix...), byte((i+1)*255 / *flag_parallel))
+				if i == *flag_parallel-1 {
+					to = cmdutil.KeyEnd(prefix)
+				}
+				tasks = append(tasks, &task{kind: kind_kv, from: from, to: to})
+				last = to
+			}
+		}
+	}
+
+	if !cmdutil.Has(skip, kind_prog) {
+		for _, v := range cmdutil.SplitList(*flag_prog) {
+			tasks = append(tasks, &task{kind: kind_prog, prog: cmdutil.ProgKey(v)})
+		}
+	}
+
+	if !cmdutil.Has(skip, kind_fo) {
+		for _, v := range cmdutil.SplitList(*flag_fo) {
+			tasks = append(tasks, &task{kind: kind_fo, fo: strings.TrimSuffix(v, "/") + "/"})
+		}
+	}
+
+	return tasks
+}
+
+func (d *differ) run(t *task) {
+	d.mu.Lock()
+	d.queue = append(d.queue, t)
+	d.cond.Signal()
+	d.mu.Unlock()
+}
+
+// descend queues the prog level below a key, or the files of a folder.
+func (d *differ) descend(it *item) {
+	switch {
+	case it.kind == kind_prog:
+		d.run(&task{kind: kind_prog, prog: cmdutil.ProgChild(it.prog)})
+	case it.dir:
+		d.run(&task{kind: kind_fo, fo: strings.TrimSuffix(it.name, "/") + "/"})
+	}
+}
+
+// work compares queued tasks until the queue is empty and no other worker
+// can add to it.
+func (d *differ) work() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+
+		for len(d.queue) == 0 && d.running > 0 {
+			d.cond.Wait()
+		}
+		if len(d.queue) == 0 {
+			d.cond.Broadcast()
+			return
+		}
+
+		t := d.queue[len(d.queue)-1]
+		d.queue = d.queue[:len(d.queue)-1]
+		d.running++
+		d.mu.Unlock()
+
+		err := d.compare(t)
+
+		d.mu.Lock()
+		d.running--
+		if err != nil {
+			d.num["error"]++
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", t.kind, task_name(t), err)
+		}
+		d.cond.Broadcast()
+	}
+}
+
+// compare merges the sorted scans of both sides.
+func (d *differ) compare(t *task) error {
+
+	var (
+		ca = newCursor(d.a, t)
+		cb = newCursor(d.b, t)
+	)
+
+	for {
+
+		ia, err := ca.peek()
+		if err != nil {
+			return fmt.Errorf("a: %s", err)
+		}
+		ib, err := cb.peek()
+		if err != nil {
+			return fmt.Errorf("b: %s", err)
+		}
+
+		switch {
+
+		case ia == nil && ib == nil:
+			return nil
+
+		case ib == nil || (ia != nil && bytes.Compare(ia.key, ib.key) < 0):
+			if !ia.dir {
+				d.report("missing", ia, "")
+			}
+			d.descend(ia)
+			ca.pop()
+
+		case ia == nil || bytes.Compare(ia.key, ib.key) > 0:
+			if !ib.dir {
+				d.report("extra", ib, "")
+			}
+			d.descend(ib)
+			cb.pop()
+
+		default:
+			switch {
+			case ia.dir && ib.dir:
+			case ia.dir:
+				d.report("extra", ib, "")
+			case ib.dir:
+				d.report("missing", ia, "")
+			default:
+				if reason, err := d.differs(ia, ib); err != nil {
+					return err
+				} else if reason != "" {
+					d.report("differ", ia, reason)
+				} else {
+					d.count("same")
+				}
+			}
+			if ia.dir {
+				d.descend(ia)
+			} else {
+				d.descend(ib)
+			}
+			ca.pop()
+			cb.pop()
+		}
+	}
+}
+
+// differs returns why two items of the same key differ, or "".
+func (d *differ) differs(a, b *item) (string, error) {
+
+	if slack := uint64(*flag_slack / time.Millisecond); a.expired != b.expired {
+		if a.expired == 0 || b.expired == 0 ||
+			a.expired > b.expired+slack || b.expired > a.expired+slack {
+			return "expiry", nil
+		}
+	}
+
+	if a.kind != kind_fo {
+		if !bytes.Equal(a.value, b.value) {
+			return "value", nil
+		}
+		return "", nil
+	}
+
+	if a.fo.Size != b.fo.Size {
+		return "size", nil
+	}
+
+	_, sa, err := d.a.FoBlockSums(a.name)
+	if err != nil {
+		return "", fmt.Errorf("a: %s: %s", a.name, err)
+	}
+	_, sb, err := d.b.FoBlockSums(b.name)
+	if err != nil {
+		return "", fmt.Errorf("b: %s: %s", b.name, err)
+	}
+
+	if len(sa) != len(sb) {
+		return "blocks", nil
+	}
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return "blocks", nil
+		}
+	}
+
+	return "", nil
+}
+
+func (d *differ) count(name string) {
+	d.mu.Lock()
+	d.num[name]++
+	d.mu.Unlock()
+}
+
+func (d *differ) report(status string, it *item, reason string) {
+
+	d.mu.Lock()
+	d.num[status]++
+
+	if !*flag_quiet {
+		if reason != "" {
+			fmt.Printf("%-8s %-5s %s (%s)\n", status, it.kind, it.name, reason)
+		} else {
+			fmt.Printf("%-8s %-5s %s\n", status, it.kind, it.name)
+		}
+	}
+
+	if d.repair != nil {
+		if err := d.repair.add(status, it); err != nil {
+			d.num["error"]++
+			fmt.Fprintf(os.Stderr, "repair %s: %s\n", it.name, err)
+		}
+	}
+
+	d.mu.Unlock()
+
+	if *flag_apply {
+		if err := apply(d.a, d.b, status, it); err != nil {
+			d.count("repair_error")
+			fmt.Fprintf(os.Stderr, "apply %s: %s\n", it.name, err)
+		} else {
+			d.count("repaired")
+		}
+	}
+}
+
+func task_name(t *task) string {
+	switch t.kind {
+	case kind_kv:
+		return fmt.Sprintf("[%q, %q)", t.from, t.to)
+	case kind_prog:
+		return cmdutil.ProgKeyString(&t.prog)
+	}
+	return t.fo
+}
@@ -0,0 +1,274 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+	"github.com/lynkdb/lynkstorgo/lynkstor/archive"
+)
+
+// repairer writes the deletes of extra keys and the missing or different
+// entries of a into an archive, and a script that loads it into b with
+// lynkstor-restore. Deletes are archive entries of the exact key, so no
+// name passes through a shell or a glob.
+type repairer struct {
+	conn   *lynkstor.Connector
+	script *os.File
+	fp     *os.File
+	w      *archive.Writer
+	name   string
+	cli    string
+}
+
+func newRepairer(name string, conn *lynkstor.Connector) (*repairer, error) {
+
+	host, port, err := net.SplitHostPort(*flag_b)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := os.Create(name + ".lkd")
+	if err != nil {
+		script.Close()
+		return nil, err
+	}
+
+	w, err := archive.NewWriter(fp)
+	if err != nil {
+		script.Close()
+		fp.Close()
+		return nil, err
+	}
+
+	r := &repairer{
+		conn:   conn,
+		script: script,
+		fp:     fp,
+		w:      w,
+		name:   name,
+		cli:    fmt.Sprintf("--host %s --port %s", sh_quote(host), port),
+	}
+	if *flag_b_auth != "" {
+		r.cli += ` --auth "$LYNKSTOR_AUTH"`
+	}
+
+	fmt.Fprintf(script, "#!/bin/sh\n# repair of %s from %s, %s\nset -e\n\n",
+		*flag_b, *flag_a, time.Now().Format(time.RFC3339))
+
+	return r, nil
+}
+
+func (r *repairer) add(status string, it *item) error {
+
+	if status == "extra" {
+		e := &archive.Entry{
+			Key: it.key,
+		}
+		switch it.kind {
+		case kind_kv:
+			e.Type = archive.EntryKvDel
+		case kind_prog:
+			e.Type = archive.EntryProgDel
+		case kind_fo:
+			e.Type, e.Key = archive.EntryFoDel, []byte(it.name)
+		}
+		return r.w.Write(e)
+	}
+
+	switch it.kind {
+
+	case kind_kv:
+		return r.w.Write(&archive.Entry{
+			Type:    archive.EntryKv,
+			Key:     it.key,
+			Expired: it.expired,
+			Value:   it.value,
+		})
+
+	case kind_prog:
+		return r.w.Write(&archive.Entry{
+			Type:    archive.EntryProg,
+			Key:     it.key,
+			Expired: it.expired,
+			Value:   it.value,
+		})
+	}
+
+	// a different file object is removed first, restore skips existing ones
+	if status == "differ" {
+		if err := r.w.Write(&archive.Entry{
+			Type: archive.EntryFoDel,
+			Key:  []byte(it.name),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := r.w.Write(&archive.Entry{
+		Type:    archive.EntryFo,
+		Key:     []byte(it.name),
+		Expired: it.expired,
+		Size:    it.fo.Size,
+	}); err != nil {
+		return err
+	}
+
+	return fo_blocks(r.conn, it.fo, func(n uint32, data []byte) error {
+		return r.w.Write(&archive.Entry{
+			Type:  archive.EntryFoBlock,
+			Key:   []byte(it.name),
+			Size:  it.fo.Size,
+			Num:   n,
+			Value: data,
+		})
+	})
+}
+
+func (r *repairer) close() error {
+
+	if err := r.w.Close(); err != nil {
+		return err
+	}
+	if err := r.fp.Close(); err != nil {
+		return err
+	}
+
+	if r.w.Num() > 0 {
+		fmt.Fprintf(r.script, "lynkstor-restore %s -i %s\n", r.cli, sh_quote(r.name+".lkd"))
+	} else {
+		os.Remove(r.name + ".lkd")
+	}
+
+	return r.script.Close()
+}
+
+// apply makes the item of b equal to a.
+func apply(a, b *lynkstor.Connector, status string, it *item) error {
+
+	var rs skv.Result
+
+	switch it.kind {
+
+	case kind_kv:
+		if status == "extra" {
+			rs = b.KvDel(it.key)
+			break
+		}
+		args := []interface{}{it.key, it.value}
+		if it.expired > 0 {
+			ttl := int64(it.expired) - time.Now().UnixNano()/1e6
+			if ttl < 1 {
+				return nil
+			}
+			args = append(args, "PX", strconv.FormatInt(ttl, 10))
+		}
+		rs = b.Cmd("kvput", args...)
+
+	case kind_prog:
+		if status == "extra" {
+			rs = b.KvProgDel(*it.prog, nil)
+			break
+		}
+		opts := &skv.KvProgWriteOptions{}
+		if it.expired > 0 {
+			opts.Expired = it.expired * 1e6
+		}
+		rs = b.KvProgPut(*it.prog, skv.KvEntry{Value: it.value}, opts)
+
+	case kind_fo:
+		if status != "missing" {
+			if rs = b.FoDel(it.name); !rs.OK() && !rs.NotFound() {
+				break
+			}
+		}
+		if status == "extra" {
+			return nil
+		}
+		return fo_copy(a, b, it.fo)
+	}
+
+	if rs != nil && !rs.OK() && !rs.NotFound() {
+		return errors.New(rs.ErrorString())
+	}
+	return nil
+}
+
+func fo_copy(a, b *lynkstor.Connector, fo_meta *skv.FileObjectEntryMeta) error {
+
+	rs := b.FoMpInit(skv.NewFileObjectEntryInit(fo_meta.Path, fo_meta.Size))
+	if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	var dst skv.FileObjectEntryMeta
+	if err := rs.Decode(&dst); err != nil {
+		return err
+	}
+
+	return fo_blocks(a, fo_meta, func(n uint32, data []byte) error {
+		blk := skv.NewFileObjectEntryBlock(fo_meta.Path, fo_meta.Size, n, data, dst.CommitKey)
+		blk.Sum = uint64(crc32.ChecksumIEEE(data))
+		if rs := b.FoMpPut(blk); !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+		return nil
+	})
+}
+
+// fo_blocks calls fn with the stored data of every block of a file object.
+func fo_blocks(conn *lynkstor.Connector, fo_meta *skv.FileObjectEntryMeta, fn func(n uint32, data []byte) error) error {
+
+	for n := uint32(0); uint64(n)*skv.FileObjectBlockSize4 < fo_meta.Size; n++ {
+
+		blk := skv.NewFileObjectEntryBlock(fo_meta.Path, 0, n, nil, "")
+		blk.Sn = fo_meta.Sn
+
+		rs := conn.FoMpGet(blk)
+		if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		var fo_block skv.FileObjectEntryBlock
+		if err := rs.Decode(&fo_block); err != nil {
+			return err
+		}
+
+		if err := fn(n, fo_block.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sh_quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/cmd/internal/cmdutil"
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+const (
+	kind_kv   = "kv"
+	kind_prog = "prog"
+	kind_fo   = "fo"
+)
+
+// item is one key of a scan. key is the key as returned by the server and
+// orders the items of both sides.
+type item struct {
+	kind    string
+	key     []byte
+	name    string
+	expired uint64
+	value   []byte
+	prog    *skv.KvProgKey
+	fo      *skv.FileObjectEntryMeta
+	dir     bool // fo folder
+}
+
+// cursor walks one task on one side, page by page.
+type cursor struct {
+	conn  *lynkstor.Connector
+	task  *task
+	items []*item
+	done  bool
+
+	// paging state
+	kv_offset   []byte
+	prog_offset skv.KvProgKey
+	fo_offset   string
+	last        string
+}
+
+func newCursor(conn *lynkstor.Connector, t *task) *cursor {
+	return &cursor{
+		conn:        conn,
+		task:        t,
+		kv_offset:   t.from,
+		prog_offset: t.prog,
+		fo_offset:   t.fo,
+	}
+}
+
+// peek returns the current item, or nil at the end of the task.
+func (c *cursor) peek() (*item, error) {
+	for len(c.items) == 0 && !c.done {
+		if err := c.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.items) == 0 {
+		return nil, nil
+	}
+	return c.items[0], nil
+}
+
+func (c *cursor) pop() {
+	if len(c.items) > 0 {
+		c.items = c.items[1:]
+	}
+}
+
+func (c *cursor) fetch() error {
+	switch c.task.kind {
+	case kind_kv:
+		return c.fetch_kv()
+	case kind_prog:
+		return c.fetch_prog()
+	}
+	return c.fetch_fo()
+}
+
+func (c *cursor) fetch_kv() error {
+
+	rs := c.conn.KvScan(c.kv_offset, c.task.to, *flag_limit)
+	if rs.NotFound() {
+		c.done = true
+		return nil
+	} else if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	ls := lynkstor.KvListRaw(rs)
+	for _, v := range ls {
+
+		if string(v.Key) == c.last {
+			continue
+		}
+		c.last = string(v.Key)
+		c.kv_offset = v.Key
+
+		// the next range starts at to
+		if bytes.Compare(v.Key, c.task.to) >= 0 {
+			c.done = true
+			return nil
+		}
+
+		expired, value := cmdutil.EntryDecode(v.Value)
+		c.items = append(c.items, &item{
+			kind:    kind_kv,
+			key:     v.Key,
+			name:    string(v.Key),
+			expired: expired,
+			value:   value,
+		})
+	}
+
+	if len(ls) < *flag_limit {
+		c.done = true
+	}
+	return nil
+}
+
+func (c *cursor) fetch_prog() error {
+
+	rs := c.conn.KvProgScan(c.prog_offset, c.task.prog, *flag_limit)
+	if rs.NotFound() {
+		c.done = true
+		return nil
+	} else if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	ls := lynkstor.KvListRaw(rs)
+	for _, v := range ls {
+
+		k := skv.ProgKeyDecode(v.Key)
+		if k == nil || len(k.Items) < 1 {
+			continue
+		}
+
+		id := string(k.Items[len(k.Items)-1].Data)
+		if id == c.last {
+			continue
+		}
+		c.last = id
+
+		if lynkstor.IsFoSumsKey(k) {
+			continue
+		}
+
+		expired, value := cmdutil.EntryDecode(v.Value)
+		c.items = append(c.items, &item{
+			kind:    kind_prog,
+			key:     v.Key,
+			name:    cmdutil.ProgKeyString(k),
+			expired: expired,
+			value:   value,
+			prog:    k,
+		})
+	}
+
+	if len(ls) < *flag_limit {
+		c.done = true
+		return nil
+	}
+
+	c.prog_offset = skv.KvProgKey{}
+	for _, v := range c.task.prog.Items[:len(c.task.prog.Items)-1] {
+		c.prog_offset.Append(v.Data)
+	}
+	c.prog_offset.Append(c.last)
+
+	return nil
+}
+
+func (c *cursor) fetch_fo() error {
+
+	rs := c.conn.FoScan(c.fo_offset, c.task.fo, *flag_limit)
+	if rs.NotFound() {
+		c.done = true
+		return nil
+	} else if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	ls := rs.KvPairs()
+	for _, v := range ls {
+
+		var fo_meta skv.FileObjectEntryMeta
+		if err := v.Decode(&fo_meta); err != nil || fo_meta.Path == "" {
+			continue
+		}
+		if fo_meta.Path == c.fo_offset {
+			continue
+		}
+		c.fo_offset = fo_meta.Path
+
+		if fo_meta.AttrAllow(skv.FileObjectEntryAttrCommiting) &&
+			!fo_meta.AttrAllow(skv.FileObjectEntryAttrIsDir) {
+			continue
+		}
+
+		expired := uint64(0)
+		if meta := v.Meta(); meta != nil {
+			expired = meta.Expired
+		}
+
+		c.items = append(c.items, &item{
+			kind:    kind_fo,
+			key:     v.KvKey(),
+			name:    fo_meta.Path,
+			expired: expired,
+			fo:      &fo_meta,
+			dir:     fo_meta.AttrAllow(skv.FileObjectEntryAttrIsDir),
+		})
+	}
+
+	if len(ls) < *flag_limit {
+		c.done = true
+	}
+	return nil
+}
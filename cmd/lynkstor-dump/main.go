@@ -0,0 +1,348 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lynkstor-dump writes raw keys, prog keys and file objects of a server
+// into an archive that lynkstor-restore loads again.
+//
+//	lynkstor-dump [flags] -o backup.lkd
+//
+// Values are kept as stored on the server, so compressed or encrypted
+// values stay so, together with their expiry time. --prog /a/b/ dumps all
+// keys [a, b, *] and the levels below each of them, --fo dumps folders with
+// their subfolders. The block sums records of file objects, prog keys under
+// _fo_sums, are internal to the server they were written on and skipped.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/cmd/internal/cmdutil"
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+	"github.com/lynkdb/lynkstorgo/lynkstor/archive"
+)
+
+var (
+	flag_host   = flag.String("host", "127.0.0.1", "server host")
+	flag_port   = flag.Int("port", 6378, "server port")
+	flag_auth   = flag.String("auth", "", "password for authentication")
+	flag_socket = flag.String("socket", "", "path of a unix socket, instead of host and port")
+	flag_out    = flag.String("o", "-", "archive file, - for stdout")
+	flag_kv     = flag.String("kv", "", "comma separated prefixes of raw keys, empty for all keys")
+	flag_prog   = flag.String("prog", "/", "comma separated prog key paths, such as /a/b/")
+	flag_fo     = flag.String("fo", "/", "comma separated file object folders")
+	flag_skip   = flag.String("skip", "", "comma separated kinds to skip: kv, prog, fo")
+	flag_limit  = flag.Int("limit", 1000, "number of keys per scan")
+)
+
+type dumper struct {
+	conn *lynkstor.Connector
+	w    *archive.Writer
+	num  map[string]int
+}
+
+func main() {
+
+	flag.Parse()
+
+	conn, err := lynkstor.NewConnector(lynkstor.Config{
+		Host:    *flag_host,
+		Port:    uint16(*flag_port),
+		Auth:    *flag_auth,
+		Socket:  *flag_socket,
+		MaxConn: 1,
+	})
+	if err != nil {
+		cmdutil.Fatal("connect: %s", err)
+	}
+	defer conn.Close()
+
+	var out io.WriteCloser = os.Stdout
+	if *flag_out != "-" {
+		if out, err = os.Create(*flag_out); err != nil {
+			cmdutil.Fatal("%s", err)
+		}
+	}
+
+	w, err := archive.NewWriter(out)
+	if err != nil {
+		cmdutil.Fatal("%s", err)
+	}
+
+	d := &dumper{
+		conn: conn,
+		w:    w,
+		num:  map[string]int{},
+	}
+
+	skip := cmdutil.SplitList(*flag_skip)
+
+	if !cmdutil.Has(skip, "kv") {
+		prefixes := cmdutil.SplitList(*flag_kv)
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		for _, v := range prefixes {
+			if err := d.kv([]byte(v)); err != nil {
+				cmdutil.Fatal("kv %q: %s", v, err)
+			}
+		}
+	}
+
+	if !cmdutil.Has(skip, "prog") {
+		for _, v := range cmdutil.SplitList(*flag_prog) {
+			if err := d.prog(cmdutil.ProgKey(v)); err != nil {
+				cmdutil.Fatal("prog %s: %s", v, err)
+			}
+		}
+	}
+
+	if !cmdutil.Has(skip, "fo") {
+		for _, v := range cmdutil.SplitList(*flag_fo) {
+			if err := d.fo(v); err != nil {
+				cmdutil.Fatal("fo %s: %s", v, err)
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		cmdutil.Fatal("%s", err)
+	}
+	if err := out.Close(); err != nil {
+		cmdutil.Fatal("%s", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "dump done, kv %d, prog %d, fo %d, blocks %d\n",
+		d.num["kv"], d.num["prog"], d.num["fo"], d.num["block"])
+}
+
+func (d *dumper) kv(prefix []byte) error {
+
+	var (
+		offset = prefix
+		cutset = cmdutil.KeyEnd(prefix)
+		last   []byte
+	)
+
+	for {
+
+		rs := d.conn.KvScan(offset, cutset, *flag_limit)
+		if rs.NotFound() {
+			return nil
+		} else if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		ls := lynkstor.KvListRaw(rs)
+		for _, v := range ls {
+
+			if last != nil && string(v.Key) == string(last) {
+				continue
+			}
+			last = v.Key
+
+			if bytes.Compare(v.Key, cutset) >= 0 {
+				return nil
+			}
+
+			expired, value := cmdutil.EntryDecode(v.Value)
+			if err := d.w.Write(&archive.Entry{
+				Type:    archive.EntryKv,
+				Key:     v.Key,
+				Expired: expired,
+				Value:   value,
+			}); err != nil {
+				return err
+			}
+			d.num["kv"]++
+		}
+
+		if len(ls) < *flag_limit {
+			return nil
+		}
+		offset = last
+	}
+}
+
+// prog dumps the keys of one level and, depth first, the levels below each
+// of them.
+func (d *dumper) prog(prefix skv.KvProgKey) error {
+
+	var (
+		offset = prefix
+		last   = ""
+	)
+
+	for {
+
+		rs := d.conn.KvProgScan(offset, prefix, *flag_limit)
+		if rs.NotFound() {
+			return nil
+		} else if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		ls := lynkstor.KvListRaw(rs)
+		for _, v := range ls {
+
+			k := skv.ProgKeyDecode(v.Key)
+			if k == nil || len(k.Items) < 1 {
+				continue
+			}
+
+			id := string(k.Items[len(k.Items)-1].Data)
+			if id == last {
+				continue
+			}
+			last = id
+
+			if lynkstor.IsFoSumsKey(k) {
+				continue
+			}
+
+			expired, value := cmdutil.EntryDecode(v.Value)
+			if err := d.w.Write(&archive.Entry{
+				Type:    archive.EntryProg,
+				Key:     v.Key,
+				Expired: expired,
+				Value:   value,
+			}); err != nil {
+				return err
+			}
+			d.num["prog"]++
+
+			if err := d.prog(cmdutil.ProgChild(k)); err != nil {
+				return err
+			}
+		}
+
+		if len(ls) < *flag_limit {
+			return nil
+		}
+
+		offset = skv.KvProgKey{}
+		for _, v := range prefix.Items[:len(prefix.Items)-1] {
+			offset.Append(v.Data)
+		}
+		offset.Append(last)
+	}
+}
+
+// fo dumps the files of a folder and, depth first, of its subfolders.
+func (d *dumper) fo(dir string) error {
+
+	var (
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+		offset = prefix
+	)
+
+	for {
+
+		rs := d.conn.FoScan(offset, prefix, *flag_limit)
+		if rs.NotFound() {
+			return nil
+		} else if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		ls := rs.KvPairs()
+		for _, v := range ls {
+
+			var fo_meta skv.FileObjectEntryMeta
+			if err := v.Decode(&fo_meta); err != nil || fo_meta.Path == "" {
+				continue
+			}
+			if fo_meta.Path == offset {
+				continue
+			}
+			offset = fo_meta.Path
+
+			if fo_meta.AttrAllow(skv.FileObjectEntryAttrIsDir) {
+				if err := d.fo(fo_meta.Path); err != nil {
+					return err
+				}
+				continue
+			}
+			if fo_meta.AttrAllow(skv.FileObjectEntryAttrCommiting) {
+				continue
+			}
+
+			expired := uint64(0)
+			if meta := v.Meta(); meta != nil {
+				expired = meta.Expired
+			}
+
+			if err := d.fo_file(&fo_meta, expired); err != nil {
+				return fmt.Errorf("%s: %s", fo_meta.Path, err)
+			}
+		}
+
+		if len(ls) < *flag_limit {
+			return nil
+		}
+	}
+}
+
+func (d *dumper) fo_file(fo_meta *skv.FileObjectEntryMeta, expired uint64) error {
+
+	if !fo_meta.AttrAllow(skv.FileObjectEntryAttrBlockSize4) {
+		return errors.New("unsupported block size")
+	}
+
+	if err := d.w.Write(&archive.Entry{
+		Type:    archive.EntryFo,
+		Key:     []byte(fo_meta.Path),
+		Expired: expired,
+		Size:    fo_meta.Size,
+	}); err != nil {
+		return err
+	}
+	d.num["fo"]++
+
+	for n := uint32(0); uint64(n)*skv.FileObjectBlockSize4 < fo_meta.Size; n++ {
+
+		blk := skv.NewFileObjectEntryBlock(fo_meta.Path, 0, n, nil, "")
+		blk.Sn = fo_meta.Sn
+
+		rs := d.conn.FoMpGet(blk)
+		if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		var fo_block skv.FileObjectEntryBlock
+		if err := rs.Decode(&fo_block); err != nil {
+			return err
+		}
+
+		if err := d.w.Write(&archive.Entry{
+			Type:  archive.EntryFoBlock,
+			Key:   []byte(fo_meta.Path),
+			Size:  fo_meta.Size,
+			Num:   n,
+			Value: fo_block.Data,
+		}); err != nil {
+			return err
+		}
+		d.num["block"]++
+	}
+
+	return nil
+}
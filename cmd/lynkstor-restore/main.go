@@ -0,0 +1,450 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lynkstor-restore loads an archive of lynkstor-dump into a server.
+//
+//	lynkstor-restore [flags] -i backup.lkd
+//
+// Entries are named by their raw key, their prog key path such as /a/b/c,
+// or their file object path, and --from/--to select a range of names.
+// --ns old=new moves the namespace old of a NamespaceConnector to new.
+// Encrypted values and blocks only open under the key they were written
+// with, so --ns fails on them instead of storing data that can not be read.
+// Expired entries are skipped, file objects are restored without expiry
+// and replace an existing object of the same path. Delete entries, as
+// lynkstor-diff writes them, remove their key.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lessos/lessgo/types"
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/cmd/internal/cmdutil"
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+	"github.com/lynkdb/lynkstorgo/lynkstor/archive"
+)
+
+var (
+	flag_host    = flag.String("host", "127.0.0.1", "server host")
+	flag_port    = flag.Int("port", 6378, "server port")
+	flag_auth    = flag.String("auth", "", "password for authentication")
+	flag_socket  = flag.String("socket", "", "path of a unix socket, instead of host and port")
+	flag_in      = flag.String("i", "-", "archive file, - for stdin")
+	flag_from    = flag.String("from", "", "restore entries whose name is >= from")
+	flag_to      = flag.String("to", "", "restore entries whose name is < to")
+	flag_ns      = flag.String("ns", "", "comma separated namespace mappings, such as old=new")
+	flag_skip    = flag.String("skip", "", "comma separated kinds to skip: kv, prog, fo")
+	flag_workers = flag.Int("workers", 4, "number of parallel writers")
+)
+
+type restorer struct {
+	conn  *lynkstor.Connector
+	nss   map[string]string
+	skip  []string
+	now   uint64
+	mu    sync.Mutex
+	num   map[string]int
+	files map[string]*foState
+}
+
+type foState struct {
+	path   string
+	commit string
+	blocks types.ArrayUint32
+	done   bool
+	failed bool
+}
+
+func main() {
+
+	flag.Parse()
+
+	if *flag_workers < 1 {
+		*flag_workers = 1
+	}
+
+	conn, err := lynkstor.NewConnector(lynkstor.Config{
+		Host:    *flag_host,
+		Port:    uint16(*flag_port),
+		Auth:    *flag_auth,
+		Socket:  *flag_socket,
+		MaxConn: *flag_workers,
+	})
+	if err != nil {
+		cmdutil.Fatal("connect: %s", err)
+	}
+	defer conn.Close()
+
+	var in io.ReadCloser = os.Stdin
+	if *flag_in != "-" {
+		if in, err = os.Open(*flag_in); err != nil {
+			cmdutil.Fatal("%s", err)
+		}
+	}
+	defer in.Close()
+
+	r, err := archive.NewReader(in)
+	if err != nil {
+		cmdutil.Fatal("%s", err)
+	}
+
+	rt := &restorer{
+		conn:  conn,
+		nss:   map[string]string{},
+		skip:  cmdutil.SplitList(*flag_skip),
+		now:   uint64(time.Now().UnixNano() / 1e6),
+		num:   map[string]int{},
+		files: map[string]*foState{},
+	}
+
+	for _, v := range cmdutil.SplitList(*flag_ns) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			cmdutil.Fatal("invalid --ns %s", v)
+		}
+		rt.nss[kv[0]] = kv[1]
+	}
+
+	var (
+		wg     sync.WaitGroup
+		queues = make([]chan *archive.Entry, *flag_workers)
+	)
+
+	// entries of one key or file object always go to the same worker, so
+	// the blocks of a file object follow its init
+	for i := range queues {
+		queues[i] = make(chan *archive.Entry, 100)
+		wg.Add(1)
+		go func(q chan *archive.Entry) {
+			defer wg.Done()
+			for e := range q {
+				rt.restore(e)
+			}
+		}(queues[i])
+	}
+
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			for _, q := range queues {
+				close(q)
+			}
+			wg.Wait()
+			cmdutil.Fatal("read: %s", err)
+		}
+
+		h := fnv.New32a()
+		h.Write(e.Key)
+		queues[h.Sum32()%uint32(len(queues))] <- e
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+
+	fmt.Printf("restore done, kv %d, prog %d, fo %d, blocks %d, deleted %d, skipped %d, expired %d, errors %d\n",
+		rt.num["kv"], rt.num["prog"], rt.num["fo"], rt.num["block"], rt.num["del"],
+		rt.num["skip"], rt.num["expired"], rt.num["error"])
+
+	if rt.num["error"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func (rt *restorer) count(name string) {
+	rt.mu.Lock()
+	rt.num[name]++
+	rt.mu.Unlock()
+}
+
+func (rt *restorer) error(name string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+	rt.count("error")
+}
+
+func (rt *restorer) restore(e *archive.Entry) {
+
+	switch e.Type {
+
+	case archive.EntryKvDel, archive.EntryProgDel, archive.EntryFoDel:
+		rt.del(e)
+
+	case archive.EntryKv:
+		if cmdutil.Has(rt.skip, "kv") || !in_range(string(e.Key)) {
+			rt.count("skip")
+			return
+		}
+		if e.Expired > 0 && e.Expired <= rt.now {
+			rt.count("expired")
+			return
+		}
+		rt.kv(e)
+
+	case archive.EntryProg:
+		k := skv.ProgKeyDecode(e.Key)
+		if k == nil || len(k.Items) < 1 {
+			rt.error(fmt.Sprintf("%q", e.Key), errors.New("invalid prog key"))
+			return
+		}
+		if cmdutil.Has(rt.skip, "prog") || lynkstor.IsFoSumsKey(k) || !in_range(cmdutil.ProgKeyString(k)) {
+			rt.count("skip")
+			return
+		}
+		if e.Expired > 0 && e.Expired <= rt.now {
+			rt.count("expired")
+			return
+		}
+		rt.prog(k, e)
+
+	case archive.EntryFo, archive.EntryFoBlock:
+		path := string(e.Key)
+		if cmdutil.Has(rt.skip, "fo") || !in_range(path) {
+			if e.Type == archive.EntryFo {
+				rt.count("skip")
+			}
+			return
+		}
+		if e.Type == archive.EntryFo && e.Expired > 0 && e.Expired <= rt.now {
+			rt.mu.Lock()
+			rt.files[path] = &foState{path: path, done: true}
+			rt.mu.Unlock()
+			rt.count("expired")
+			return
+		}
+		if e.Type == archive.EntryFo {
+			rt.fo_init(e)
+		} else {
+			rt.fo_block(e)
+		}
+	}
+}
+
+func (rt *restorer) del(e *archive.Entry) {
+
+	var (
+		name string
+		rs   skv.Result
+	)
+
+	switch e.Type {
+
+	case archive.EntryKvDel:
+		if name = string(e.Key); cmdutil.Has(rt.skip, "kv") || !in_range(name) {
+			rt.count("skip")
+			return
+		}
+		rs = rt.conn.KvDel(rt.kv_key(e.Key))
+
+	case archive.EntryProgDel:
+		k := skv.ProgKeyDecode(e.Key)
+		if k == nil || len(k.Items) < 1 {
+			rt.error(fmt.Sprintf("%q", e.Key), errors.New("invalid prog key"))
+			return
+		}
+		if name = cmdutil.ProgKeyString(k); cmdutil.Has(rt.skip, "prog") || lynkstor.IsFoSumsKey(k) || !in_range(name) {
+			rt.count("skip")
+			return
+		}
+		rs = rt.conn.KvProgDel(rt.prog_key(k), nil)
+
+	default:
+		if name = string(e.Key); cmdutil.Has(rt.skip, "fo") || !in_range(name) {
+			rt.count("skip")
+			return
+		}
+		rs = rt.conn.FoDel(rt.fo_path(name))
+	}
+
+	if !rs.OK() && !rs.NotFound() {
+		rt.error(name, errors.New(rs.ErrorString()))
+		return
+	}
+	rt.count("del")
+}
+
+func (rt *restorer) kv_key(key []byte) []byte {
+	if n := strings.IndexByte(string(key), ':'); n > 0 {
+		if ns, ok := rt.nss[string(key[:n])]; ok {
+			return append([]byte(ns), key[n:]...)
+		}
+	}
+	return key
+}
+
+func (rt *restorer) prog_key(k *skv.KvProgKey) skv.KvProgKey {
+	if ns, ok := rt.nss[string(k.Items[0].Data)]; ok {
+		k.Items[0] = &skv.KvProgKeyEntry{
+			Type: k.Items[0].Type,
+			Data: []byte(ns),
+		}
+	}
+	return *k
+}
+
+func (rt *restorer) kv(e *archive.Entry) {
+
+	key := rt.kv_key(e.Key)
+	if err := moved_encrypted(string(key) != string(e.Key), e.Value); err != nil {
+		rt.error(string(e.Key), err)
+		return
+	}
+
+	// the value is written as stored, without encoding it again
+	args := []interface{}{key, e.Value}
+	if e.Expired > 0 {
+		args = append(args, "PX", strconv.FormatUint(e.Expired-rt.now, 10))
+	}
+
+	if rs := rt.conn.Cmd("kvput", args...); !rs.OK() {
+		rt.error(string(key), errors.New(rs.ErrorString()))
+		return
+	}
+	rt.count("kv")
+}
+
+func (rt *restorer) prog(k *skv.KvProgKey, e *archive.Entry) {
+
+	name := cmdutil.ProgKeyString(k)
+	key := rt.prog_key(k)
+	if err := moved_encrypted(cmdutil.ProgKeyString(&key) != name, e.Value); err != nil {
+		rt.error(name, err)
+		return
+	}
+
+	opts := &skv.KvProgWriteOptions{}
+	if e.Expired > 0 {
+		opts.Expired = e.Expired * 1e6
+	}
+
+	if rs := rt.conn.KvProgPut(key, skv.KvEntry{Value: e.Value}, opts); !rs.OK() {
+		rt.error(cmdutil.ProgKeyString(&key), errors.New(rs.ErrorString()))
+		return
+	}
+	rt.count("prog")
+}
+
+func (rt *restorer) fo_path(path string) string {
+	if ls := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2); len(ls) == 2 {
+		if ns, ok := rt.nss[ls[0]]; ok {
+			return "/" + ns + "/" + ls[1]
+		}
+	}
+	return path
+}
+
+func (rt *restorer) fo_init(e *archive.Entry) {
+
+	st := &foState{
+		path: rt.fo_path(string(e.Key)),
+	}
+
+	rt.mu.Lock()
+	rt.files[string(e.Key)] = st
+	rt.mu.Unlock()
+
+	// an upload resumes onto an existing object of the same size and keeps
+	// its content, so the object is replaced
+	if rs := rt.conn.FoDel(st.path); !rs.OK() && !rs.NotFound() {
+		st.failed = true
+		rt.error(st.path, errors.New(rs.ErrorString()))
+		return
+	}
+
+	rs := rt.conn.FoMpInit(skv.NewFileObjectEntryInit(st.path, e.Size))
+	if !rs.OK() {
+		st.failed = true
+		rt.error(st.path, errors.New(rs.ErrorString()))
+		return
+	}
+
+	var fo_meta skv.FileObjectEntryMeta
+	if err := rs.Decode(&fo_meta); err != nil {
+		st.failed = true
+		rt.error(st.path, err)
+		return
+	}
+
+	if !fo_meta.AttrAllow(skv.FileObjectEntryAttrCommiting) {
+		st.done = true
+	}
+	st.commit = fo_meta.CommitKey
+	st.blocks = types.ArrayUint32(fo_meta.Blocks)
+
+	rt.count("fo")
+}
+
+func (rt *restorer) fo_block(e *archive.Entry) {
+
+	rt.mu.Lock()
+	st, ok := rt.files[string(e.Key)]
+	rt.mu.Unlock()
+
+	if !ok {
+		rt.error(string(e.Key), errors.New("block without file object"))
+		return
+	}
+
+	if st.failed || st.done || st.blocks.Has(e.Num) {
+		return
+	}
+
+	if err := moved_encrypted(st.path != string(e.Key), e.Value); err != nil {
+		st.failed = true
+		rt.error(st.path, err)
+		return
+	}
+
+	blk := skv.NewFileObjectEntryBlock(st.path, e.Size, e.Num, e.Value, st.commit)
+	blk.Sum = uint64(crc32.ChecksumIEEE(e.Value))
+
+	if rs := rt.conn.FoMpPut(blk); !rs.OK() {
+		st.failed = true
+		rt.error(st.path, errors.New(rs.ErrorString()))
+		return
+	}
+	rt.count("block")
+}
+
+// moved_encrypted fails for encrypted data restored under another key.
+func moved_encrypted(moved bool, value []byte) error {
+	if moved && lynkstor.IsEncrypted(value) {
+		return errors.New("encrypted data can not move to another namespace")
+	}
+	return nil
+}
+
+func in_range(name string) bool {
+	if *flag_from != "" && name < *flag_from {
+		return false
+	}
+	if *flag_to != "" && name >= *flag_to {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive reads and writes the portable dump format of
+// lynkstor-dump and lynkstor-restore.
+//
+// An archive is the magic "LYNKDUMP", one version byte and a gzip stream
+// of entries:
+//
+//	type     uint8
+//	key      uvarint length, bytes
+//	expired  uvarint, absolute unix milliseconds, 0 for never
+//	size     uvarint, file object size
+//	num      uvarint, file object block number
+//	value    uvarint length, bytes
+//
+// The stream ends with an entry of type EntryEnd whose size holds the
+// number of entries written, so truncated archives are detected.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	Version uint8 = 1
+
+	EntryEnd     uint8 = 0
+	EntryKv      uint8 = 1 // raw key, stored value
+	EntryProg    uint8 = 2 // encoded prog key, stored value
+	EntryFo      uint8 = 3 // file object path and size
+	EntryFoBlock uint8 = 4 // file object block, follows its EntryFo
+	EntryKvDel   uint8 = 5 // raw key to delete
+	EntryProgDel uint8 = 6 // encoded prog key to delete
+	EntryFoDel   uint8 = 7 // file object path to delete
+)
+
+var (
+	magic = []byte("LYNKDUMP")
+
+	// largest key or value accepted by the reader
+	bytes_max uint64 = 64 << 20
+
+	ErrFormat    = errors.New("archive: invalid format")
+	ErrTruncated = errors.New("archive: truncated")
+)
+
+type Entry struct {
+	Type    uint8
+	Key     []byte
+	Expired uint64
+	Size    uint64
+	Num     uint32
+	Value   []byte
+}
+
+type Writer struct {
+	zw  *gzip.Writer
+	buf []byte
+	num uint64
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+
+	if _, err := w.Write(append(append([]byte{}, magic...), Version)); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		zw:  gzip.NewWriter(w),
+		buf: make([]byte, binary.MaxVarintLen64),
+	}, nil
+}
+
+func (w *Writer) Write(e *Entry) error {
+	if e.Type == EntryEnd {
+		return errors.New("archive: invalid entry type")
+	}
+	if err := w.write(e); err != nil {
+		return err
+	}
+	w.num++
+	return nil
+}
+
+func (w *Writer) write(e *Entry) error {
+
+	if _, err := w.zw.Write([]byte{e.Type}); err != nil {
+		return err
+	}
+	if err := w.bytes(e.Key); err != nil {
+		return err
+	}
+	for _, v := range []uint64{e.Expired, e.Size, uint64(e.Num)} {
+		if err := w.uvarint(v); err != nil {
+			return err
+		}
+	}
+	return w.bytes(e.Value)
+}
+
+func (w *Writer) uvarint(v uint64) error {
+	n := binary.PutUvarint(w.buf, v)
+	_, err := w.zw.Write(w.buf[:n])
+	return err
+}
+
+func (w *Writer) bytes(bs []byte) error {
+	if err := w.uvarint(uint64(len(bs))); err != nil {
+		return err
+	}
+	_, err := w.zw.Write(bs)
+	return err
+}
+
+// Num returns the number of entries written.
+func (w *Writer) Num() uint64 {
+	return w.num
+}
+
+// Close writes the end entry and flushes the stream, it does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.write(&Entry{Type: EntryEnd, Size: w.num}); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+type Reader struct {
+	zr  *bufio.Reader
+	num uint64
+	end bool
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrFormat
+	}
+	if !bytes.Equal(head[:len(magic)], magic) {
+		return nil, ErrFormat
+	}
+	if head[len(magic)] != Version {
+		return nil, errors.New("archive: unsupported version")
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		zr: bufio.NewReader(zr),
+	}, nil
+}
+
+// Next returns the next entry, or io.EOF after the last one.
+func (r *Reader) Next() (*Entry, error) {
+
+	if r.end {
+		return nil, io.EOF
+	}
+
+	t, err := r.zr.ReadByte()
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	e := &Entry{
+		Type: t,
+	}
+
+	if e.Key, err = r.bytes(); err != nil {
+		return nil, err
+	}
+	if e.Expired, err = r.uvarint(); err != nil {
+		return nil, err
+	}
+	if e.Size, err = r.uvarint(); err != nil {
+		return nil, err
+	}
+	num, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	e.Num = uint32(num)
+	if e.Value, err = r.bytes(); err != nil {
+		return nil, err
+	}
+
+	switch e.Type {
+
+	case EntryEnd:
+		if e.Size != r.num {
+			return nil, ErrTruncated
+		}
+		r.end = true
+		return nil, io.EOF
+
+	case EntryKv, EntryProg, EntryFo, EntryFoBlock,
+		EntryKvDel, EntryProgDel, EntryFoDel:
+		r.num++
+		return e, nil
+	}
+
+	return nil, ErrFormat
+}
+
+func (r *Reader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(r.zr)
+	if err != nil {
+		return 0, ErrTruncated
+	}
+	return v, nil
+}
+
+func (r *Reader) bytes() ([]byte, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > bytes_max {
+		return nil, ErrFormat
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(r.zr, bs); err != nil {
+		return nil, ErrTruncated
+	}
+	return bs, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var test_entries = []*Entry{
+	{Type: EntryKv, Key: []byte("key"), Expired: 1600000000000, Value: []byte("value")},
+	{Type: EntryProg, Key: []byte{7, 1, 'a', 1, 'b'}, Value: []byte{}},
+	{Type: EntryFo, Key: []byte("/a/file"), Size: 5 << 20, Value: []byte{}},
+	{Type: EntryFoBlock, Key: []byte("/a/file"), Size: 5 << 20, Num: 1, Value: bytes.Repeat([]byte{0xff}, 1<<20)},
+	{Type: EntryKvDel, Key: []byte("old"), Value: []byte{}},
+	{Type: EntryProgDel, Key: []byte{7, 1, 'c'}, Value: []byte{}},
+	{Type: EntryFoDel, Key: []byte("/a/old"), Value: []byte{}},
+}
+
+func test_archive(t *testing.T, close bool) []byte {
+
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range test_entries {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Num() != uint64(len(test_entries)) {
+		t.Fatalf("%d entries written", w.Num())
+	}
+
+	if close {
+		err = w.Close()
+	} else {
+		// flush the stream without the end entry
+		err = w.zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func test_read(data []byte) ([]*Entry, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	ls := []*Entry{}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return ls, nil
+		} else if err != nil {
+			return ls, err
+		}
+		ls = append(ls, e)
+	}
+}
+
+func TestArchive(t *testing.T) {
+
+	ls, err := test_read(test_archive(t, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ls, test_entries) {
+		t.Fatalf("read %d entries, differ from the written ones", len(ls))
+	}
+
+	if err := (&Writer{}).Write(&Entry{Type: EntryEnd}); err == nil {
+		t.Fatal("end entry written")
+	}
+}
+
+func TestArchiveInvalid(t *testing.T) {
+
+	var (
+		data = test_archive(t, true)
+		head = len(magic) + 1
+	)
+
+	unknown := func() []byte {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf)
+		w.write(&Entry{Type: 99})
+		w.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrFormat},
+		{"magic", append([]byte("LYNKDUMQ"), data[len(magic):]...), ErrFormat},
+		{"truncated", data[:len(data)/2], ErrTruncated},
+		{"no end entry", test_archive(t, false), ErrTruncated},
+		{"unknown entry", unknown(), ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := test_read(tt.data); err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	version := append([]byte{}, data...)
+	version[head-1] = Version + 1
+	if _, err := test_read(version); err == nil {
+		t.Fatal("unsupported version read")
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+var (
+	ErrBulkClosed = errors.New("bulk writer closed")
+)
+
+type BulkOptions struct {
+
+	// Number of commands per pipelined batch, default to 100
+	BatchSize int
+
+	// Maximum number of batches in flight, default to the MaxConn of the
+	// connector. Put and Del block while the window is full
+	Window int
+
+	// Retries of a failed item, default to 3, negative for none. These are
+	// the only retries, batches are not resent by the connector
+	Retries int
+
+	// Wait before the first retry, doubled on each one, default to 100ms
+	Backoff time.Duration
+
+	// Maximum wait before a partial batch is sent, default to 100ms
+	FlushInterval time.Duration
+
+	// Called with the running totals after every batch, from the writing
+	// goroutines
+	Progress func(stats BulkStats)
+
+	// Called with every item that still failed after all retries
+	Error func(key []byte, err error)
+}
+
+type BulkStats struct {
+	Puts    int64
+	Dels    int64
+	Errors  int64
+	Retries int64 // resends of items whose own write failed
+	Elapsed time.Duration
+}
+
+type bulkItem struct {
+	key []byte
+	cmd *cmdEntry
+}
+
+// BulkWriter groups puts and deletes of many goroutines into pipelined
+// batches over all pooled connections. Items are sharded to the batches by
+// key, so the writes of one key are applied in the order they were queued.
+type BulkWriter struct {
+	conn    *Connector
+	opts    BulkOptions
+	key     func(key []byte) []byte
+	queue   chan *bulkItem
+	batches []chan []*bulkItem
+	flush   chan struct{}
+	wg      sync.WaitGroup
+	start   time.Time
+
+	qmu    sync.RWMutex
+	closed bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int
+	stats   BulkStats
+}
+
+func (c *Connector) NewBulkWriter(opts BulkOptions) *BulkWriter {
+	return bulk_writer_new(c, opts, nil)
+}
+
+// bulk_writer_new returns a writer that maps every key with key_fn, if it
+// is set.
+func bulk_writer_new(c *Connector, opts BulkOptions, key_fn func(key []byte) []byte) *BulkWriter {
+
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 100
+	}
+	if opts.Window < 1 {
+		opts.Window = c.cfg.MaxConn
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 100 * time.Millisecond
+	}
+
+	w := &BulkWriter{
+		conn:    c,
+		opts:    opts,
+		key:     key_fn,
+		queue:   make(chan *bulkItem, opts.BatchSize*opts.Window),
+		batches: make([]chan []*bulkItem, opts.Window),
+		flush:   make(chan struct{}, 1),
+		start:   time.Now(),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	for i := range w.batches {
+		w.batches[i] = make(chan []*bulkItem)
+		w.wg.Add(1)
+		go w.run(w.batches[i])
+	}
+
+	go w.dispatch()
+
+	return w
+}
+
+// Put queues a write of key, encoded the same way as KvPut.
+func (w *BulkWriter) Put(key []byte, value interface{}, opts *skv.KvWriteOptions) error {
+
+	if w.key != nil {
+		key = w.key(key)
+	}
+
+	cmd, err := w.conn.kv_put_cmd(key, value, opts)
+	if err != nil {
+		return err
+	}
+
+	return w.add(&bulkItem{key, cmd})
+}
+
+// Del queues a delete of key.
+func (w *BulkWriter) Del(key []byte) error {
+	if w.key != nil {
+		key = w.key(key)
+	}
+	return w.add(&bulkItem{key, &cmdEntry{"kvdel", []interface{}{key}}})
+}
+
+func (w *BulkWriter) add(it *bulkItem) error {
+
+	w.qmu.RLock()
+	defer w.qmu.RUnlock()
+
+	if w.closed {
+		return ErrBulkClosed
+	}
+
+	w.mu.Lock()
+	w.pending++
+	w.mu.Unlock()
+
+	w.queue <- it
+	return nil
+}
+
+// Flush sends the queued items and waits until all of them are written or
+// failed.
+func (w *BulkWriter) Flush() {
+
+	select {
+	case w.flush <- struct{}{}:
+	default:
+	}
+
+	w.mu.Lock()
+	for w.pending > 0 {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
+
+// Close writes the queued items, stops the writer and returns the totals.
+func (w *BulkWriter) Close() BulkStats {
+
+	w.qmu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.qmu.Unlock()
+
+	w.wg.Wait()
+
+	return w.Stats()
+}
+
+// Stats returns the running totals.
+func (w *BulkWriter) Stats() BulkStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Elapsed = time.Since(w.start)
+	return stats
+}
+
+func (w *BulkWriter) dispatch() {
+
+	var (
+		batches = make([][]*bulkItem, len(w.batches))
+		tick    = time.NewTicker(w.opts.FlushInterval)
+	)
+	defer tick.Stop()
+
+	send := func(i int) {
+		if len(batches[i]) > 0 {
+			w.batches[i] <- batches[i]
+			batches[i] = nil
+		}
+	}
+
+	send_all := func() {
+		for i := range batches {
+			send(i)
+		}
+	}
+
+	add := func(it *bulkItem) {
+		h := fnv.New32a()
+		h.Write(it.key)
+		i := int(h.Sum32() % uint32(len(batches)))
+		if batches[i] = append(batches[i], it); len(batches[i]) >= w.opts.BatchSize {
+			send(i)
+		}
+	}
+
+	for {
+		select {
+
+		case it, ok := <-w.queue:
+			if !ok {
+				send_all()
+				for _, v := range w.batches {
+					close(v)
+				}
+				return
+			}
+			add(it)
+
+		case <-tick.C:
+			send_all()
+
+		case <-w.flush:
+			// take what is queued now, then send the partial batches
+			for n := len(w.queue); n > 0; n-- {
+				add(<-w.queue)
+			}
+			send_all()
+		}
+	}
+}
+
+func (w *BulkWriter) run(batches chan []*bulkItem) {
+	defer w.wg.Done()
+	for batch := range batches {
+		w.write(batch)
+	}
+}
+
+// write sends a batch through one pooled connection and resends the
+// failed items with backoff. The later items of a key that is resent are
+// resent with it, to keep their order, and run on the server again even if
+// they succeeded, which puts and deletes allow. Only the failed items are
+// counted as retries.
+func (w *BulkWriter) write(items []*bulkItem) {
+
+	for try, retries := 0, 0; len(items) > 0; try++ {
+
+		if try > 0 {
+			time.Sleep(w.opts.Backoff << uint(try-1))
+			w.mu.Lock()
+			w.stats.Retries += int64(retries)
+			w.mu.Unlock()
+		}
+
+		cmds := make([]*cmdEntry, len(items))
+		for i, it := range items {
+			cmds[i] = it.cmd
+		}
+		rss := w.conn.pipe_tries(cmds, 1)
+
+		var (
+			failed = []*bulkItem{}
+			resend = map[string]bool{}
+		)
+		retries = 0
+		for i, it := range items {
+
+			rs := rss[i]
+
+			if resend[string(it.key)] {
+				failed = append(failed, it)
+				continue
+			}
+
+			if rs.OK() || (rs.NotFound() && it.cmd.cmd == "kvdel") {
+				w.done(it, nil)
+				continue
+			}
+
+			if try >= w.opts.Retries || rs.status == skv.ResultBadArgument {
+				w.done(it, errors.New(rs.ErrorString()))
+				continue
+			}
+
+			failed = append(failed, it)
+			resend[string(it.key)] = true
+			retries++
+		}
+		items = failed
+	}
+
+	if w.opts.Progress != nil {
+		w.opts.Progress(w.Stats())
+	}
+}
+
+func (w *BulkWriter) done(it *bulkItem, err error) {
+
+	w.conn.cache_kv_del(it.key)
+
+	if err != nil && w.opts.Error != nil {
+		w.opts.Error(it.key, err)
+	}
+
+	w.mu.Lock()
+	switch {
+	case err != nil:
+		w.stats.Errors++
+	case it.cmd.cmd == "kvdel":
+		w.stats.Dels++
+	default:
+		w.stats.Puts++
+	}
+	if w.pending--; w.pending == 0 {
+		w.cond.Broadcast()
+	}
+	w.mu.Unlock()
+}
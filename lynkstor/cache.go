@@ -0,0 +1,203 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lynkdb/iomix/skv"
+)
+
+type CacheOptions struct {
+
+	// Maximum number of cached keys, default to 10000
+	Size int
+
+	// Maximum time a value is served from the cache, default to 10 seconds.
+	// Values that expire earlier on the server leave the cache with them
+	Ttl time.Duration
+}
+
+// cache is a size bounded LRU of KvGet and KvProgGet results. Concurrent
+// misses of one key share a single fetch. Writes through the connector
+// remove their keys, writes by other clients are seen after Ttl.
+type cache struct {
+	mu    sync.Mutex
+	opts  CacheOptions
+	ll    *list.List
+	items map[string]*list.Element
+	calls map[string]*cacheCall
+	gen   uint64
+}
+
+type cacheEntry struct {
+	key     string
+	rs      *Result
+	expired time.Time
+}
+
+type cacheCall struct {
+	wg sync.WaitGroup
+	rs *Result
+}
+
+// SetCache enables the local read cache of KvGet and KvProgGet, nil
+// disables it. It may be called while other goroutines use the connector.
+func (c *Connector) SetCache(opts *CacheOptions) {
+
+	if opts == nil {
+		c.cache.Store((*cache)(nil))
+		return
+	}
+
+	if opts.Size < 1 {
+		opts.Size = 10000
+	}
+	if opts.Ttl <= 0 {
+		opts.Ttl = 10 * time.Second
+	}
+
+	c.cache.Store(&cache{
+		opts:  *opts,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		calls: map[string]*cacheCall{},
+	})
+}
+
+func (c *Connector) read_cache() *cache {
+	ca, _ := c.cache.Load().(*cache)
+	return ca
+}
+
+func (c *cache) get(key string, fn func() skv.Result) skv.Result {
+
+	c.mu.Lock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expired) {
+			c.ll.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.rs.copy()
+		}
+		c.remove(elem)
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.rs.copy()
+	}
+
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	gen := c.gen
+	c.mu.Unlock()
+
+	rs, ok := fn().(*Result)
+	if !ok {
+		rs = newResult(skv.ResultError, nil)
+	}
+	call.rs = rs
+	call.wg.Done()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	// skip the store if a write happened while fetching
+	if rs.OK() && gen == c.gen {
+		c.set(key, rs)
+	}
+	c.mu.Unlock()
+
+	return rs.copy()
+}
+
+func (c *cache) set(key string, rs *Result) {
+
+	expired := time.Now().Add(c.opts.Ttl)
+	if meta := rs.Meta(); meta != nil && meta.Expired > 0 {
+		if t := time.Unix(0, int64(meta.Expired)*1e6); t.Before(expired) {
+			expired = t
+		}
+	}
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry{
+		key:     key,
+		rs:      rs,
+		expired: expired,
+	})
+
+	for c.ll.Len() > c.opts.Size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *cache) remove(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).key)
+}
+
+func (c *cache) del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *Connector) cache_kv_del(keys ...[]byte) {
+	if ca := c.read_cache(); ca != nil {
+		ks := []string{}
+		for _, v := range keys {
+			ks = append(ks, "k"+string(v))
+		}
+		ca.del(ks...)
+	}
+}
+
+func (c *Connector) cache_prog_del(key skv.KvProgKey) {
+	if ca := c.read_cache(); ca != nil {
+		if bs, err := proto.Marshal(&key); err == nil {
+			ca.del("p" + string(bs))
+		}
+	}
+}
+
+// copy returns a result that shares the read only data of rs, so cached
+// results are never modified by their readers.
+func (rs *Result) copy() *Result {
+	return &Result{
+		status: rs.status,
+		key:    rs.key,
+		data:   rs.data,
+		cap:    rs.cap,
+		items:  rs.items,
+		plain:  rs.plain,
+		crypt:  rs.crypt,
+		aad:    rs.aad,
+	}
+}
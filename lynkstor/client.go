@@ -32,6 +32,10 @@ var (
 	err_auth   = errors.New("auth failed")
 )
 
+var (
+	ErrNotFound = errors.New("not found")
+)
+
 type client struct {
 	num    int
 	sock   net.Conn
@@ -41,7 +45,7 @@ type client struct {
 
 func newClient(copts *connOptions, num int) (*client, error) {
 
-	sock, err := net.Dial(copts.net, copts.addr)
+	sock, err := copts.connect()
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +66,18 @@ func newClient(copts *connOptions, num int) (*client, error) {
 	return cli, nil
 }
 
+func (o *connOptions) connect() (net.Conn, error) {
+	if o.dial != nil {
+		return o.dial(o.net, o.addr)
+	}
+	return net.Dial(o.net, o.addr)
+}
+
+type cmdEntry struct {
+	cmd  string
+	args []interface{}
+}
+
 func (c *client) cmd(cmd string, args ...interface{}) skv.Result {
 
 	buf, err := send_buf_cmd(cmd, args)
@@ -69,8 +85,68 @@ func (c *client) cmd(cmd string, args ...interface{}) skv.Result {
 		return newResult(skv.ResultBadArgument, err)
 	}
 
+	if rs := c.send(buf); rs != nil {
+		return rs
+	}
+
+	rs, err := c.cmd_parse()
+	if err != nil {
+		// a reply that is cut off or still on the way would be read by the
+		// next command, so drop the connection
+		c.Close()
+		return cmd_parse_error(err)
+	}
+
+	return rs
+}
+
+// pipe writes all commands in one batch and then reads their replies in
+// order. If the batch can not be sent every entry reports the same error.
+func (c *client) pipe(cmds []*cmdEntry) []*Result {
+
+	var (
+		buf bytes.Buffer
+		rss = make([]*Result, len(cmds))
+	)
+
+	for _, v := range cmds {
+		bs, err := send_buf_cmd(v.cmd, v.args)
+		if err != nil {
+			for i := range rss {
+				rss[i] = newResult(skv.ResultBadArgument, err)
+			}
+			return rss
+		}
+		buf.Write(bs)
+	}
+
+	if rs := c.send(buf.Bytes()); rs != nil {
+		for i := range rss {
+			rss[i] = rs
+		}
+		return rss
+	}
+
+	for i := range cmds {
+		rs, err := c.cmd_parse()
+		if err != nil {
+			// the reply stream is out of sync, so drop the connection
+			c.Close()
+			for ; i < len(rss); i++ {
+				rss[i] = cmd_parse_error(err)
+			}
+			break
+		}
+		rss[i] = rs
+	}
+
+	return rss
+}
+
+func (c *client) send(buf []byte) *Result {
+
 	if c.sock == nil {
-		sock, err := net.Dial(c.copts.net, c.copts.addr)
+		sock, err := c.copts.connect()
 		if err != nil {
 			return newResult(skv.ResultNetError, err)
 		}
@@ -100,15 +176,14 @@ func (c *client) cmd(cmd string, args ...interface{}) skv.Result {
 		}
 	}
 
-	rs, err := c.cmd_parse()
-	if err != nil {
-		if ev, ok := err.(*net.OpError); ok && ev.Timeout() {
-			return newResult(skv.ResultTimeout, err)
-		}
-		return newResult(skv.ResultNetError, err)
-	}
+	return nil
+}
 
-	return rs
+func cmd_parse_error(err error) *Result {
+	if ev, ok := err.(net.Error); ok && ev.Timeout() {
+		return newResult(skv.ResultTimeout, err)
+	}
+	return newResult(skv.ResultNetError, err)
 }
 
 func (c *client) cmd_parse() (*Result, error) {
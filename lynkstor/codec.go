@@ -0,0 +1,229 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lynkdb/iomix/skv"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	value_ns_gob      uint8 = 21
+	value_ns_protobuf uint8 = 22
+	value_ns_msgpack  uint8 = 23
+)
+
+// Codec encodes structured values. Every codec owns one value namespace
+// byte, which is written in front of the encoded value so that
+// Result.Decode can select the matching decoder.
+type Codec interface {
+	Name() string
+	Ns() uint8
+	Encode(obj interface{}) ([]byte, error)
+	Decode(bs []byte, obj interface{}) error
+}
+
+var (
+	CodecGob      Codec = &codecGob{}
+	CodecProtobuf Codec = &codecProtobuf{}
+	CodecMsgpack  Codec = &codecMsgpack{}
+
+	codec_mu  sync.RWMutex
+	codec_set = map[uint8]Codec{}
+)
+
+func init() {
+	RegisterCodec(CodecGob)
+	RegisterCodec(CodecProtobuf)
+	RegisterCodec(CodecMsgpack)
+}
+
+// RegisterCodec adds a codec to the registry. The namespaces of bytes,
+// json, compressed and encrypted values are reserved.
+func RegisterCodec(c Codec) error {
+
+	if c.Ns() == value_ns_bytes || c.Ns() == value_ns_json ||
+		c.Ns() == kvobj_t_v1 || c.Ns() == value_ns_compress ||
+		c.Ns() == value_ns_crypt {
+		return errors.New("codec namespace reserved")
+	}
+
+	codec_mu.Lock()
+	defer codec_mu.Unlock()
+
+	if v, ok := codec_set[c.Ns()]; ok && v.Name() != c.Name() {
+		return errors.New("codec namespace already in use by " + v.Name())
+	}
+	codec_set[c.Ns()] = c
+
+	return nil
+}
+
+func codec_get(ns uint8) Codec {
+	codec_mu.RLock()
+	defer codec_mu.RUnlock()
+	return codec_set[ns]
+}
+
+func codec_lookup(name string) Codec {
+	codec_mu.RLock()
+	defer codec_mu.RUnlock()
+	for _, v := range codec_set {
+		if v.Name() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// codecRef holds the default codec of a connector in an atomic.Value,
+// which needs one concrete type for every codec and nil.
+type codecRef struct {
+	codec Codec
+}
+
+type codecValue struct {
+	codec Codec
+	obj   interface{}
+}
+
+// CodecValue selects the codec of a single write, for example
+// conn.KvPut(key, lynkstor.CodecValue(lynkstor.CodecGob, obj), nil).
+func CodecValue(c Codec, obj interface{}) interface{} {
+	return &codecValue{
+		codec: c,
+		obj:   obj,
+	}
+}
+
+// NewCodecEntry encodes obj into a KvEntry for the KvProg* APIs.
+func NewCodecEntry(c Codec, obj interface{}) (skv.KvEntry, error) {
+	bs, err := value_encode(c, obj)
+	if err != nil {
+		return skv.KvEntry{}, err
+	}
+	return skv.KvEntry{Value: bs}, nil
+}
+
+func value_encode(c Codec, value interface{}) ([]byte, error) {
+
+	if cv, ok := value.(*codecValue); ok {
+		c, value = cv.codec, cv.obj
+	}
+
+	if c == nil {
+		return skv.ValueEncodeBytes(value, nil), nil
+	}
+
+	// plain values keep the default encoding so that String() and Int()
+	// still work on them
+	switch value.(type) {
+	case nil, []byte, string, bool,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64,
+		float32, float64:
+		return skv.ValueEncodeBytes(value, nil), nil
+	}
+
+	bs, err := c.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{c.Ns()}, bs...), nil
+}
+
+func value_decode(bs []byte, obj interface{}) error {
+	if len(bs) > 0 {
+		if c := codec_get(bs[0]); c != nil {
+			return c.Decode(bs[1:], obj)
+		}
+	}
+	return skv.ValueDecode(bs, obj)
+}
+
+func (cn *Connector) kv_entry(value interface{}) (skv.KvEntry, error) {
+	return NewCodecEntry(cn.Codec(), value)
+}
+
+type codecGob struct{}
+
+func (codecGob) Name() string {
+	return "gob"
+}
+
+func (codecGob) Ns() uint8 {
+	return value_ns_gob
+}
+
+func (codecGob) Encode(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codecGob) Decode(bs []byte, obj interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(bs)).Decode(obj)
+}
+
+type codecProtobuf struct{}
+
+func (codecProtobuf) Name() string {
+	return "protobuf"
+}
+
+func (codecProtobuf) Ns() uint8 {
+	return value_ns_protobuf
+}
+
+func (codecProtobuf) Encode(obj interface{}) ([]byte, error) {
+	if m, ok := obj.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return nil, errors.New("protobuf: value is not a proto.Message")
+}
+
+func (codecProtobuf) Decode(bs []byte, obj interface{}) error {
+	if m, ok := obj.(proto.Message); ok {
+		return proto.Unmarshal(bs, m)
+	}
+	return errors.New("protobuf: value is not a proto.Message")
+}
+
+type codecMsgpack struct{}
+
+func (codecMsgpack) Name() string {
+	return "msgpack"
+}
+
+func (codecMsgpack) Ns() uint8 {
+	return value_ns_msgpack
+}
+
+func (codecMsgpack) Encode(obj interface{}) ([]byte, error) {
+	return msgpack.Marshal(obj)
+}
+
+func (codecMsgpack) Decode(bs []byte, obj interface{}) error {
+	return msgpack.Unmarshal(bs, obj)
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type codecObject struct {
+	Name string
+	Num  int64
+	Tags []string
+}
+
+type testCodec struct {
+	name string
+	ns   uint8
+}
+
+func (c *testCodec) Name() string                            { return c.name }
+func (c *testCodec) Ns() uint8                               { return c.ns }
+func (c *testCodec) Encode(obj interface{}) ([]byte, error)  { return nil, nil }
+func (c *testCodec) Decode(bs []byte, obj interface{}) error { return nil }
+
+func TestCodecRoundTrip(t *testing.T) {
+
+	obj := &codecObject{
+		Name: "name",
+		Num:  -42,
+		Tags: []string{"a", "b"},
+	}
+
+	for _, c := range []Codec{CodecGob, CodecMsgpack} {
+		t.Run(c.Name(), func(t *testing.T) {
+
+			bs, err := value_encode(c, obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bs[0] != c.Ns() {
+				t.Fatalf("namespace %d, want %d", bs[0], c.Ns())
+			}
+
+			var dec codecObject
+			if err := value_decode(bs, &dec); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&dec, obj) {
+				t.Fatalf("decoded %+v, want %+v", dec, obj)
+			}
+
+			// CodecValue selects the codec of one write
+			cv, err := value_encode(nil, CodecValue(c, obj))
+			if err != nil || !bytes.Equal(cv, bs) {
+				t.Fatalf("CodecValue encoded %q, %v", cv, err)
+			}
+
+			// plain values keep the default encoding
+			if bs, err := value_encode(c, "plain"); err != nil || (len(bs) > 0 && bs[0] == c.Ns()) {
+				t.Fatalf("plain value encoded %q, %v", bs, err)
+			}
+		})
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		codec Codec
+		ok    bool
+	}{
+		{"bytes", &testCodec{"test-bytes", value_ns_bytes}, false},
+		{"json", &testCodec{"test-json", value_ns_json}, false},
+		{"entry", &testCodec{"test-entry", kvobj_t_v1}, false},
+		{"compress", &testCodec{"test-compress", value_ns_compress}, false},
+		{"crypt", &testCodec{"test-crypt", value_ns_crypt}, false},
+		{"taken", &testCodec{"test-gob", value_ns_gob}, false},
+		{"again", CodecGob, true},
+		{"free", &testCodec{"test-free", 90}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterCodec(tt.codec)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && codec_lookup(tt.codec.Name()) != tt.codec {
+				t.Fatal("codec not found after registration")
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"errors"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+var (
+	collection_scan_limit = 100
+)
+
+// CollectionConnector is the part of a connector a collection uses, it is
+// implemented by Connector and NamespaceConnector.
+type CollectionConnector interface {
+	KvProgPut(key skv.KvProgKey, val skv.KvEntry, opts *skv.KvProgWriteOptions) skv.Result
+	KvProgGet(key skv.KvProgKey) skv.Result
+	KvProgDel(key skv.KvProgKey, opts *skv.KvProgWriteOptions) skv.Result
+	KvProgScan(offset, cutset skv.KvProgKey, limit int) skv.Result
+	Codec() Codec
+}
+
+// Collection maps values of type T to the prog keys [ns, id]. Secondary
+// index entries are stored as [ns#idx, name, value, id] and are rewritten
+// on every Put and Delete. Index updates are not atomic with the data write.
+type Collection[T any] struct {
+	conn    CollectionConnector
+	ns      string
+	codec   Codec
+	indexes []*collectionIndex[T]
+}
+
+type collectionIndex[T any] struct {
+	name string
+	fn   func(obj *T) string
+}
+
+func NewCollection[T any](cn CollectionConnector, ns string) *Collection[T] {
+	return &Collection[T]{
+		conn: cn,
+		ns:   ns,
+	}
+}
+
+// WithCodec sets the codec of values written by this collection, by default
+// the codec of the connector is used.
+func (c *Collection[T]) WithCodec(codec Codec) *Collection[T] {
+	c.codec = codec
+	return c
+}
+
+// Index registers a secondary index. fn returns the index value of an
+// object, an empty value skips the entry.
+func (c *Collection[T]) Index(name string, fn func(obj *T) string) *Collection[T] {
+	c.indexes = append(c.indexes, &collectionIndex[T]{
+		name: name,
+		fn:   fn,
+	})
+	return c
+}
+
+func (c *Collection[T]) key(id string) skv.KvProgKey {
+	return skv.NewKvProgKey(c.ns, id)
+}
+
+func (c *Collection[T]) index_key(name, value, id string) skv.KvProgKey {
+	return skv.NewKvProgKey(c.ns+"#idx", name, value, id)
+}
+
+func (c *Collection[T]) Get(id string) (T, error) {
+	var obj T
+	rs := c.conn.KvProgGet(c.key(id))
+	if rs.NotFound() {
+		return obj, ErrNotFound
+	} else if !rs.OK() {
+		return obj, errors.New(rs.ErrorString())
+	}
+	err := rs.Decode(&obj)
+	return obj, err
+}
+
+func (c *Collection[T]) Put(id string, obj T) error {
+
+	var prev *T
+	if len(c.indexes) > 0 {
+		if v, err := c.Get(id); err == nil {
+			prev = &v
+		} else if err != ErrNotFound {
+			return err
+		}
+	}
+
+	codec := c.codec
+	if codec == nil {
+		codec = c.conn.Codec()
+	}
+	entry, err := NewCodecEntry(codec, obj)
+	if err != nil {
+		return err
+	}
+
+	if rs := c.conn.KvProgPut(c.key(id), entry, nil); !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	for _, idx := range c.indexes {
+		value := idx.fn(&obj)
+		if prev != nil {
+			if pv := idx.fn(prev); pv != "" && pv != value {
+				if rs := c.conn.KvProgDel(c.index_key(idx.name, pv, id), nil); !rs.OK() && !rs.NotFound() {
+					return errors.New(rs.ErrorString())
+				}
+			}
+		}
+		if value == "" {
+			continue
+		}
+		if rs := c.conn.KvProgPut(c.index_key(idx.name, value, id), skv.NewKvEntry(id), nil); !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+	}
+
+	return nil
+}
+
+func (c *Collection[T]) Delete(id string) error {
+
+	if len(c.indexes) > 0 {
+		prev, err := c.Get(id)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, idx := range c.indexes {
+			if v := idx.fn(&prev); v != "" {
+				if rs := c.conn.KvProgDel(c.index_key(idx.name, v, id), nil); !rs.OK() && !rs.NotFound() {
+					return errors.New(rs.ErrorString())
+				}
+			}
+		}
+	}
+
+	if rs := c.conn.KvProgDel(c.key(id), nil); !rs.OK() && !rs.NotFound() {
+		return errors.New(rs.ErrorString())
+	}
+
+	return nil
+}
+
+// Each calls fn for every object in id order until fn returns false.
+func (c *Collection[T]) Each(fn func(id string, obj T) bool) error {
+	return c.scan(c.key(""), func(id string, rs skv.Result) (bool, error) {
+		var obj T
+		if err := rs.Decode(&obj); err != nil {
+			return false, err
+		}
+		return fn(id, obj), nil
+	})
+}
+
+// Find returns the objects whose index name has the given value.
+func (c *Collection[T]) Find(name, value string) ([]T, error) {
+
+	var (
+		ls  []T
+		err error
+	)
+
+	err2 := c.scan(c.index_key(name, value, ""), func(id string, rs skv.Result) (bool, error) {
+		var obj T
+		if obj, err = c.Get(id); err == ErrNotFound {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		ls = append(ls, obj)
+		return true, nil
+	})
+
+	return ls, err2
+}
+
+func (c *Collection[T]) scan(prefix skv.KvProgKey, fn func(id string, rs skv.Result) (bool, error)) error {
+
+	var (
+		offset = prefix
+		last   = ""
+	)
+
+	for {
+
+		rs := c.conn.KvProgScan(offset, prefix, collection_scan_limit)
+		if rs.NotFound() {
+			return nil
+		} else if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		ls := rs.KvPairs()
+		for _, v := range ls {
+
+			k := skv.ProgKeyDecode(v.KvKey())
+			if k == nil || len(k.Items) < 1 {
+				continue
+			}
+
+			id := string(k.Items[len(k.Items)-1].Data)
+			if id == last {
+				continue
+			}
+			last = id
+
+			if next, err := fn(id, v); err != nil || !next {
+				return err
+			}
+		}
+
+		if len(ls) < collection_scan_limit {
+			return nil
+		}
+
+		offset = skv.KvProgKey{}
+		for _, v := range prefix.Items[:len(prefix.Items)-1] {
+			offset.Append(v.Data)
+		}
+		offset.Append(last)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// A compressed value is stored as
+//
+//	value_ns_compress, algorithm, compressed(encoded value)
+//
+// where the encoded value still starts with its own namespace byte.
+const (
+	value_ns_compress uint8 = 28
+
+	CompressNone   uint8 = 0
+	CompressGzip   uint8 = 1
+	CompressSnappy uint8 = 2
+	CompressZstd   uint8 = 3
+
+	compress_threshold_def = 1024
+)
+
+var (
+	err_compress = errors.New("invalid compressed value")
+	zstd_enc, _  = zstd.NewWriter(nil)
+	zstd_dec, _  = zstd.NewReader(nil)
+)
+
+func compress_lookup(name string) (uint8, error) {
+	switch name {
+	case "", "none":
+		return CompressNone, nil
+	case "gzip":
+		return CompressGzip, nil
+	case "snappy":
+		return CompressSnappy, nil
+	case "zstd":
+		return CompressZstd, nil
+	}
+	return CompressNone, errors.New("compress algorithm not found: " + name)
+}
+
+// SetCompress enables the compression of values written through this
+// connector. Values shorter than threshold bytes are stored as is. Values
+// are always decompressed on read, whatever this setting is. It may be
+// called while other goroutines use the connector.
+func (c *Connector) SetCompress(algo uint8, threshold int) {
+	if threshold < 1 {
+		threshold = compress_threshold_def
+	}
+	c.compress.Store(compressRef{algo, threshold})
+}
+
+type compressRef struct {
+	algo      uint8
+	threshold int
+}
+
+func (c *Connector) value_compress(bs []byte) []byte {
+
+	cr, _ := c.compress.Load().(compressRef)
+	if cr.algo == CompressNone || len(bs) < cr.threshold ||
+		bs[0] == value_ns_compress {
+		return bs
+	}
+
+	var enc []byte
+
+	switch cr.algo {
+
+	case CompressGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(bs); err != nil {
+			return bs
+		}
+		if err := w.Close(); err != nil {
+			return bs
+		}
+		enc = buf.Bytes()
+
+	case CompressSnappy:
+		enc = snappy.Encode(nil, bs)
+
+	case CompressZstd:
+		enc = zstd_enc.EncodeAll(bs, nil)
+
+	default:
+		return bs
+	}
+
+	if len(enc)+2 >= len(bs) {
+		return bs
+	}
+
+	return append([]byte{value_ns_compress, cr.algo}, enc...)
+}
+
+func value_decompress(bs []byte) ([]byte, error) {
+
+	if len(bs) < 3 || bs[0] != value_ns_compress {
+		return nil, err_compress
+	}
+
+	switch bs[1] {
+
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(bs[2:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+
+	case CompressSnappy:
+		return snappy.Decode(nil, bs[2:])
+
+	case CompressZstd:
+		return zstd_dec.DecodeAll(bs[2:], nil)
+	}
+
+	return nil, err_compress
+}
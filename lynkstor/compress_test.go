@@ -0,0 +1,95 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+
+	var (
+		long   = bytes.Repeat([]byte("lynkstor compress "), 100)
+		short  = []byte("short value")
+		random = make([]byte, 4096)
+	)
+	rand.Read(random)
+
+	tests := []struct {
+		name       string
+		value      []byte
+		compressed bool
+	}{
+		{"long", long, true},
+		{"short", short, false},
+
+		// values that do not get smaller are stored as is
+		{"random", random, false},
+	}
+
+	for _, algo := range []string{"gzip", "snappy", "zstd"} {
+
+		id, err := compress_lookup(algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := &Connector{}
+		c.SetCompress(id, 64)
+
+		for _, tt := range tests {
+			t.Run(algo+"/"+tt.name, func(t *testing.T) {
+
+				bs := c.value_compress(tt.value)
+				if compressed := bs[0] == value_ns_compress; compressed != tt.compressed {
+					t.Fatalf("compressed %v, want %v", compressed, tt.compressed)
+				}
+				if !tt.compressed {
+					if !bytes.Equal(bs, tt.value) {
+						t.Fatal("value changed")
+					}
+					return
+				}
+				if bs[1] != id || len(bs) >= len(tt.value) {
+					t.Fatalf("algorithm %d, %d bytes of %d", bs[1], len(bs), len(tt.value))
+				}
+
+				// a compressed value is not compressed again
+				if again := c.value_compress(bs); !bytes.Equal(again, bs) {
+					t.Fatal("value compressed twice")
+				}
+
+				dec, err := value_decompress(bs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(dec, tt.value) {
+					t.Fatal("decompressed value differs")
+				}
+
+				rs := NewResult(0, bs)
+				if !bytes.Equal(rs.Bytes(), tt.value) {
+					t.Fatal("Result.Bytes not decompressed")
+				}
+			})
+		}
+	}
+
+	if _, err := value_decompress([]byte{value_ns_compress, 99, 1, 2, 3}); err == nil {
+		t.Fatal("unknown algorithm decompressed")
+	}
+}
@@ -15,6 +15,8 @@
 package lynkstor
 
 import (
+	"net"
+
 	"github.com/lynkdb/iomix/connect"
 )
 
@@ -37,6 +39,26 @@ type Config struct {
 
 	// Maximum number of connections
 	MaxConn int `json:"maxconn"`
+
+	// Name of the default value codec (gob, protobuf, msgpack). Leave blank
+	// to write structured values as json
+	Codec string `json:"codec"`
+
+	// Compression of written values (gzip, snappy, zstd). Leave blank to
+	// store values uncompressed
+	Compress string `json:"compress"`
+
+	// Minimum value size in bytes to compress, default to 1024
+	CompressThreshold int `json:"compress_threshold"`
+
+	// Interceptors around every command, the first one is called first.
+	// Every command of a pipelined batch goes through the chain, those that
+	// reach its end together are still sent as one pipeline
+	Interceptors []Interceptor `json:"-"`
+
+	// Dial function of new connections, such as the fault injecting proxy
+	// of lynkstortest. Leave blank to use net.Dial
+	Dialer func(network, addr string) (net.Conn, error) `json:"-"`
 }
 
 func NewConfig(copts connect.ConnOptions) Config {
@@ -71,5 +93,17 @@ func NewConfig(copts connect.ConnOptions) Config {
 		cfg.MaxConn = v.Int()
 	}
 
+	if v, ok := copts.Items.Get("codec"); ok {
+		cfg.Codec = v.String()
+	}
+
+	if v, ok := copts.Items.Get("compress"); ok {
+		cfg.Compress = v.String()
+	}
+
+	if v, ok := copts.Items.Get("compress_threshold"); ok {
+		cfg.CompressThreshold = v.Int()
+	}
+
 	return cfg
 }
@@ -0,0 +1,253 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+// An encrypted value or file object block is stored as
+//
+//	value_ns_crypt, len(key id), key id, nonce, AES-GCM(data)
+//
+// Values are compressed before they are encrypted. The key of a value, or
+// the path and number of a block, is passed to AES-GCM as additional data,
+// so that data copied to another key does not open.
+const (
+	value_ns_crypt uint8 = 29
+)
+
+var (
+	err_crypt = errors.New("invalid encrypted value")
+
+	// ErrCryptPrevSum is returned for writes with PrevSum on an encrypting
+	// connector. The server compares PrevSum with the sum of the stored
+	// ciphertext, which the caller can not know.
+	ErrCryptPrevSum = errors.New("PrevSum can not be used with encrypted values")
+)
+
+// IsEncrypted reports whether a value or file object block, as stored on
+// the server, is encrypted. Such data only opens under its own key.
+func IsEncrypted(bs []byte) bool {
+	return len(bs) > 2 && bs[0] == value_ns_crypt
+}
+
+// CryptKeyring holds the AES keys of the client side encryption. New data
+// is sealed with the primary key, older keys stay in the keyring so that
+// data written before a key rotation is still readable.
+type CryptKeyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+func NewCryptKeyring() *CryptKeyring {
+	return &CryptKeyring{
+		keys: map[string]cipher.AEAD{},
+	}
+}
+
+// AddKey adds an AES-128, AES-192 or AES-256 key. The first key added
+// becomes the primary key.
+func (kr *CryptKeyring) AddKey(id string, key []byte) error {
+
+	if len(id) < 1 || len(id) > 255 {
+		return errors.New("invalid key id")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys[id] = aead
+	if kr.primary == "" {
+		kr.primary = id
+	}
+
+	return nil
+}
+
+// SetPrimary selects the key used to encrypt new data.
+func (kr *CryptKeyring) SetPrimary(id string) error {
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[id]; !ok {
+		return errors.New("key not found: " + id)
+	}
+	kr.primary = id
+
+	return nil
+}
+
+func (kr *CryptKeyring) seal(bs, aad []byte) ([]byte, error) {
+
+	kr.mu.RLock()
+	id, aead := kr.primary, kr.keys[kr.primary]
+	kr.mu.RUnlock()
+
+	if aead == nil {
+		return nil, errors.New("no primary key")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	enc := make([]byte, 0, 2+len(id)+len(nonce)+len(bs)+aead.Overhead())
+	enc = append(enc, value_ns_crypt, uint8(len(id)))
+	enc = append(enc, id...)
+	enc = append(enc, nonce...)
+
+	return aead.Seal(enc, nonce, bs, aad), nil
+}
+
+func (kr *CryptKeyring) open(bs, aad []byte) ([]byte, error) {
+
+	if len(bs) < 3 || bs[0] != value_ns_crypt {
+		return nil, err_crypt
+	}
+
+	offset := 2 + int(bs[1])
+	if offset > len(bs) {
+		return nil, err_crypt
+	}
+
+	kr.mu.RLock()
+	aead := kr.keys[string(bs[2:offset])]
+	kr.mu.RUnlock()
+
+	if aead == nil {
+		return nil, errors.New("key not found: " + string(bs[2:offset]))
+	}
+
+	if offset+aead.NonceSize() > len(bs) {
+		return nil, err_crypt
+	}
+
+	return aead.Open(nil, bs[offset:offset+aead.NonceSize()], bs[offset+aead.NonceSize():], aad)
+}
+
+// SetCrypt enables the encryption of values and file object blocks written
+// through this connector, nil disables it. Data is decrypted on read as
+// long as its key is in the keyring. Encrypted numbers can not be changed
+// by KvIncr and KvProgIncr on the server, and prog writes or deletes with
+// PrevSum fail with ErrCryptPrevSum. It may be called while other
+// goroutines use the connector.
+func (c *Connector) SetCrypt(kr *CryptKeyring) {
+	c.crypt.Store(kr)
+}
+
+func (c *Connector) keyring() *CryptKeyring {
+	kr, _ := c.crypt.Load().(*CryptKeyring)
+	return kr
+}
+
+func (c *Connector) value_encrypt(bs, aad []byte) ([]byte, error) {
+	kr := c.keyring()
+	if kr == nil || len(bs) == 0 {
+		return bs, nil
+	}
+	return kr.seal(bs, aad)
+}
+
+func (c *Connector) value_decrypt(bs, aad []byte) ([]byte, error) {
+	kr := c.keyring()
+	if kr == nil || !IsEncrypted(bs) {
+		return bs, nil
+	}
+	return kr.open(bs, aad)
+}
+
+// result_open decrypts the value of a result, a value that does not open
+// turns the result into an error.
+func (c *Connector) result_open(rs skv.Result, aad []byte) skv.Result {
+	if v, ok := rs.(*Result); ok && c.keyring() != nil && v.OK() {
+		v.crypt_open(aad)
+	}
+	return rs
+}
+
+// result_list_open decrypts the values of the pairs of a scan, aad returns
+// the additional data of a key.
+func (c *Connector) result_list_open(rs skv.Result, aad func(key []byte) []byte) skv.Result {
+	v, ok := rs.(*Result)
+	if !ok || c.keyring() == nil || !v.OK() {
+		return rs
+	}
+	for i := 1; i < len(v.items); i += 2 {
+		if item := v.items[i]; !item.crypt_open(aad(v.items[i-1].data)) {
+			return newResult(skv.ResultError, fmt.Errorf("%q: %s", v.items[i-1].data, item.data))
+		}
+	}
+	return rs
+}
+
+func crypt_aad_kv(key []byte) []byte {
+	return append([]byte{'k'}, key...)
+}
+
+func crypt_aad_prog(key *skv.KvProgKey) []byte {
+	var (
+		aad = []byte{'p'}
+		buf [binary.MaxVarintLen64]byte
+	)
+	for _, v := range key.Items {
+		n := binary.PutUvarint(buf[:], uint64(len(v.Data)))
+		aad = append(append(aad, buf[:n]...), v.Data...)
+	}
+	return aad
+}
+
+// crypt_aad_prog_raw returns the additional data of a prog key as the
+// server returns it in scans.
+func crypt_aad_prog_raw(key []byte) []byte {
+	if k := skv.ProgKeyDecode(key); k != nil {
+		return crypt_aad_prog(k)
+	}
+	return nil
+}
+
+func crypt_aad_fo(path string, num uint32) []byte {
+	var buf [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(buf[:], uint64(num))
+	return append(append([]byte{'f'}, buf[:n]...), path...)
+}
+
+func (rs *Result) crypt_set(kr *CryptKeyring) {
+	rs.crypt = kr
+	for _, v := range rs.items {
+		v.crypt_set(kr)
+	}
+}
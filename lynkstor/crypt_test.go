@@ -0,0 +1,170 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+func TestCryptSealOpen(t *testing.T) {
+
+	kr := NewCryptKeyring()
+	if err := kr.AddKey("k1", bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		value = []byte("secret value")
+		aad   = crypt_aad_kv([]byte("key"))
+	)
+
+	enc, err := kr.seal(value, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(enc) || bytes.Contains(enc, value) {
+		t.Fatal("value not encrypted")
+	}
+
+	tests := []struct {
+		name string
+		aad  []byte
+		ok   bool
+	}{
+		{"same key", aad, true},
+		{"other key", crypt_aad_kv([]byte("key2")), false},
+		{"prog key", crypt_aad_prog(&skv.KvProgKey{Items: []*skv.KvProgKeyEntry{{Data: []byte("key")}}}), false},
+		{"no aad", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := kr.open(enc, tt.aad)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !bytes.Equal(dec, value) {
+				t.Fatal("opened value differs")
+			}
+		})
+	}
+
+	for _, bs := range [][]byte{nil, value, {value_ns_crypt, 9, 'x'}, enc[:len(enc)-1]} {
+		if _, err := kr.open(bs, aad); err == nil {
+			t.Fatalf("%q opened", bs)
+		}
+	}
+}
+
+func TestCryptRotation(t *testing.T) {
+
+	var (
+		kr    = NewCryptKeyring()
+		value = []byte("value")
+		aad   = crypt_aad_fo("/a/b", 3)
+	)
+
+	if _, err := kr.seal(value, aad); err == nil {
+		t.Fatal("sealed without a key")
+	}
+
+	kr.AddKey("k1", bytes.Repeat([]byte{1}, 16))
+	old, _ := kr.seal(value, aad)
+
+	kr.AddKey("k2", bytes.Repeat([]byte{2}, 32))
+	if err := kr.SetPrimary("k3"); err == nil {
+		t.Fatal("unknown primary key accepted")
+	}
+	if err := kr.SetPrimary("k2"); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := kr.seal(value, aad)
+
+	tests := []struct {
+		name string
+		enc  []byte
+		id   string
+	}{
+		{"before rotation", old, "k1"},
+		{"after rotation", cur, "k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if id := string(tt.enc[2 : 2+int(tt.enc[1])]); id != tt.id {
+				t.Fatalf("sealed with %s, want %s", id, tt.id)
+			}
+			if dec, err := kr.open(tt.enc, aad); err != nil || !bytes.Equal(dec, value) {
+				t.Fatalf("open: %q, %v", dec, err)
+			}
+
+			// a keyring without the key can not open it
+			other := NewCryptKeyring()
+			other.AddKey("k9", bytes.Repeat([]byte{9}, 16))
+			if _, err := other.open(tt.enc, aad); err == nil {
+				t.Fatal("opened without its key")
+			}
+		})
+	}
+
+	if crypt_aad_fo("/a/b", 3) == nil || bytes.Equal(crypt_aad_fo("/a/b", 3), crypt_aad_fo("/a/b", 4)) {
+		t.Fatal("blocks share their additional data")
+	}
+}
+
+func TestCryptConnector(t *testing.T) {
+
+	var (
+		c     = &Connector{}
+		value = bytes.Repeat([]byte("v"), 100)
+		aad   = crypt_aad_kv([]byte("key"))
+	)
+
+	// no keyring, data passes through
+	if bs, _ := c.value_encrypt(value, aad); !bytes.Equal(bs, value) {
+		t.Fatal("encrypted without a keyring")
+	}
+
+	kr := NewCryptKeyring()
+	kr.AddKey("k1", bytes.Repeat([]byte{1}, 16))
+	c.SetCrypt(kr)
+	c.SetCompress(CompressGzip, 10)
+
+	enc, err := c.value_encrypt(c.value_compress(value), aad)
+	if err != nil || !IsEncrypted(enc) {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	rs := NewResult(skv.ResultOK, enc)
+	rs.crypt_set(kr)
+	if !rs.crypt_open(aad) || !bytes.Equal(rs.Bytes(), value) {
+		t.Fatalf("result value %q", rs.Bytes())
+	}
+
+	rs = NewResult(skv.ResultOK, enc)
+	rs.crypt_set(kr)
+	if rs.crypt_open(crypt_aad_kv([]byte("other"))) || rs.OK() {
+		t.Fatal("value opened under another key")
+	}
+
+	c.SetCrypt(nil)
+	if bs, _ := c.value_decrypt(enc, aad); !bytes.Equal(bs, enc) {
+		t.Fatal("decrypted without a keyring")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"sync"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+// Invoker runs a command, either the next interceptor of the chain or the
+// pooled connection.
+type Invoker func(cmd string, args ...interface{}) skv.Result
+
+// Interceptor wraps every command of a connector. It may change cmd and
+// args before calling next, call next several times, look at the result,
+// or return a result of its own without calling next. One call of an
+// interceptor must not call next concurrently. For example:
+//
+//	func timing(cmd string, args []interface{}, next lynkstor.Invoker) skv.Result {
+//		start := time.Now()
+//		rs := next(cmd, args...)
+//		metrics.Observe(cmd, time.Since(start), rs.Status())
+//		return rs
+//	}
+type Interceptor func(cmd string, args []interface{}, next Invoker) skv.Result
+
+// NewResult returns a result with status and data, for interceptors that
+// answer a command themselves.
+func NewResult(status uint8, data []byte) *Result {
+	return &Result{
+		status: status,
+		data:   data,
+		cap:    len(data),
+	}
+}
+
+// chain returns the invoker of interceptors around last, the first one of
+// the list is called first.
+func chain(interceptors []Interceptor, last Invoker) Invoker {
+	next := last
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, inner := interceptors[i], next
+		next = func(cmd string, args ...interface{}) skv.Result {
+			return ic(cmd, args, inner)
+		}
+	}
+	return next
+}
+
+// result_of converts the result an interceptor returned, keeping its meta
+// and list items.
+func result_of(rs skv.Result) *Result {
+	if v, ok := rs.(*Result); ok {
+		return v
+	}
+	if !rs.OK() {
+		return &Result{
+			status: rs.Status(),
+			data:   []byte(rs.ErrorString()),
+		}
+	}
+	data := rs.Bytes()
+	if meta := rs.Meta(); meta != nil {
+		if enc, err := RepEntryEncode(meta, data); err == nil {
+			data = enc
+		}
+	}
+	v := NewResult(rs.Status(), data)
+	for _, item := range rs.List() {
+		v.items = append(v.items, result_of(item))
+	}
+	v.cap = len(v.data) + len(v.items)
+	return v
+}
+
+type batchCall struct {
+	cmd  *cmdEntry
+	done chan *Result
+}
+
+// batch runs pipelined commands through the interceptors. Every command
+// goes through the chain in a goroutine of its own, and the commands that
+// reach the end of the chain meanwhile are sent as one pipeline, so that
+// interceptors do not cost the pipelining.
+func (c *Connector) batch(cmds []*cmdEntry, tries int) []*Result {
+
+	var (
+		mu   sync.Mutex
+		cond = sync.NewCond(&mu)
+		rss  = make([]*Result, len(cmds))
+
+		// goroutines neither done nor waiting for the pipeline
+		running = len(cmds)
+		waiting []*batchCall
+	)
+
+	last := func(cmd string, args ...interface{}) skv.Result {
+		call := &batchCall{
+			cmd:  &cmdEntry{cmd, args},
+			done: make(chan *Result, 1),
+		}
+		mu.Lock()
+		waiting = append(waiting, call)
+		running--
+		cond.Signal()
+		mu.Unlock()
+		return <-call.done
+	}
+
+	invoke := chain(c.cfg.Interceptors, last)
+
+	for i, v := range cmds {
+		go func(i int, v *cmdEntry) {
+			rs := result_of(invoke(v.cmd, v.args...))
+			if kr := c.keyring(); kr != nil {
+				rs.crypt_set(kr)
+			}
+			mu.Lock()
+			rss[i] = rs
+			running--
+			cond.Signal()
+			mu.Unlock()
+		}(i, v)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for {
+
+		for running > 0 {
+			cond.Wait()
+		}
+		if len(waiting) == 0 {
+			return rss
+		}
+
+		calls := waiting
+		waiting = nil
+		running += len(calls)
+		mu.Unlock()
+
+		ls := make([]*cmdEntry, len(calls))
+		for i, call := range calls {
+			ls[i] = call.cmd
+		}
+		for i, rs := range c.pipe_conn(ls, tries) {
+			calls[i].done <- rs
+		}
+
+		mu.Lock()
+	}
+}
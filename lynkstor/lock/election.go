@@ -0,0 +1,127 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Election elects one leader among the replicas that campaign for the same
+// name. Unlike a Lock, leadership is given up on the first failed renewal,
+// including network errors, so that two replicas never both act as leader.
+type Election struct {
+	locker *Locker
+	name   string
+	ttl    time.Duration
+	mu     sync.Mutex
+	lock   *Lock
+}
+
+func (l *Locker) Election(name string, ttl time.Duration) *Election {
+	return &Election{
+		locker: l,
+		name:   name,
+		ttl:    ttl,
+	}
+}
+
+// Campaign blocks until this replica becomes the leader or ctx is done.
+func (e *Election) Campaign(ctx context.Context) error {
+
+	if e.IsLeader() {
+		return nil
+	}
+
+	for {
+		lk, err := e.locker.try_acquire(e.name, e.ttl, true)
+		if err == nil {
+			e.mu.Lock()
+			e.lock = lk
+			e.mu.Unlock()
+			return nil
+		} else if err != ErrLocked {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.ttl / 3):
+		}
+	}
+}
+
+// Resign gives up the leadership.
+func (e *Election) Resign() error {
+
+	e.mu.Lock()
+	lk := e.lock
+	e.lock = nil
+	e.mu.Unlock()
+
+	if lk == nil {
+		return ErrNotHeld
+	}
+
+	return lk.Release()
+}
+
+func (e *Election) IsLeader() bool {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock == nil {
+		return false
+	}
+
+	select {
+	case <-e.lock.lost:
+		return false
+	default:
+	}
+
+	return true
+}
+
+// Lost returns a channel that is closed when the current leadership is lost.
+// It is nil if this replica is not the leader.
+func (e *Election) Lost() <-chan struct{} {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock == nil {
+		return nil
+	}
+
+	return e.lock.lost
+}
+
+// Term returns the fencing token of the current leadership, which increases
+// with every new leader.
+func (e *Election) Term() uint64 {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock == nil {
+		return 0
+	}
+
+	return e.lock.Fence
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lock implements lease based distributed locks.
+//
+// A lock is the prog key [ns, name] created with KvProgNew and a TTL. Raw
+// keys have no compare-and-set, so renewal and release use the PrevSum
+// write option of prog keys to act only while the key still holds our
+// token. Value encryption on the connector must be off for lock keys.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+var (
+	ErrLocked  = errors.New("lock is held by another owner")
+	ErrNotHeld = errors.New("lock is not held")
+
+	retry_interval = 100 * time.Millisecond
+)
+
+type Locker struct {
+	conn skv.Connector
+	ns   string
+}
+
+type Lock struct {
+	// Fence is a token that increases with every acquisition of the same
+	// name. Pass it to the guarded resource to reject stale owners.
+	Fence uint64
+
+	locker *Locker
+	name   string
+	token  string
+	ttl    time.Duration
+	mu     sync.Mutex
+	lost   chan struct{}
+	done   chan struct{}
+	closed bool
+	strict bool
+}
+
+// New returns a Locker that keeps its keys under the prog key namespace ns,
+// default to "_lock".
+func New(conn skv.Connector, ns string) *Locker {
+	if ns == "" {
+		ns = "_lock"
+	}
+	return &Locker{
+		conn: conn,
+		ns:   ns,
+	}
+}
+
+// Acquire blocks until the lock is acquired or ctx is done. The lease is
+// renewed in the background until Release is called.
+func (l *Locker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		lk, err := l.TryAcquire(name, ttl)
+		if err != ErrLocked {
+			return lk, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retry_interval):
+		}
+	}
+}
+
+// TryAcquire acquires the lock once and returns ErrLocked if it is held.
+func (l *Locker) TryAcquire(name string, ttl time.Duration) (*Lock, error) {
+	return l.try_acquire(name, ttl, false)
+}
+
+func (l *Locker) try_acquire(name string, ttl time.Duration, strict bool) (*Lock, error) {
+
+	if name == "" || ttl < time.Second {
+		return nil, errors.New("invalid name or ttl")
+	}
+
+	token, err := token_new()
+	if err != nil {
+		return nil, err
+	}
+
+	key := l.key(name)
+	rs := l.conn.KvProgNew(key, skv.NewKvEntry(token), &skv.KvProgWriteOptions{
+		Expired: expired(ttl),
+	})
+	switch rs.Status() {
+	case skv.ResultNetError, skv.ResultTimeout, skv.ResultNoAuth, skv.ResultBadArgument:
+		return nil, errors.New(rs.ErrorString())
+	}
+
+	// KvProgNew keeps an existing key, read it back to see who owns it
+	if rs = l.conn.KvProgGet(key); rs.NotFound() {
+		return nil, ErrLocked
+	} else if !rs.OK() {
+		return nil, errors.New(rs.ErrorString())
+	} else if rs.String() != token {
+		return nil, ErrLocked
+	}
+
+	lk := &Lock{
+		locker: l,
+		name:   name,
+		token:  token,
+		ttl:    ttl,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+		strict: strict,
+	}
+
+	if rs = l.conn.KvIncr([]byte(l.ns+":fence:"+name), 1); !rs.OK() {
+		lk.Release()
+		return nil, errors.New(rs.ErrorString())
+	}
+	lk.Fence = rs.Uint64()
+
+	go lk.renew()
+
+	return lk, nil
+}
+
+func (l *Locker) key(name string) skv.KvProgKey {
+	return skv.NewKvProgKey(l.ns, name)
+}
+
+// Lost is closed when the lease could not be renewed before it expired.
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Release stops the renewal and deletes the key if it still holds our
+// token. It returns ErrNotHeld if the lease was lost before.
+func (lk *Lock) Release() error {
+
+	lk.mu.Lock()
+	if lk.closed {
+		lk.mu.Unlock()
+		return ErrNotHeld
+	}
+	lk.closed = true
+	close(lk.done)
+	lk.mu.Unlock()
+
+	rs := lk.locker.conn.KvProgDel(lk.locker.key(lk.name), &skv.KvProgWriteOptions{
+		PrevSum: crc32.ChecksumIEEE([]byte(lk.token)),
+	})
+	if rs.NotFound() {
+		return ErrNotHeld
+	} else if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	return nil
+}
+
+func (lk *Lock) renew() {
+
+	var (
+		interval = lk.ttl / 3
+		deadline = time.Now().Add(lk.ttl)
+	)
+
+	for {
+
+		select {
+		case <-lk.done:
+			return
+		case <-time.After(interval):
+		}
+
+		rs := lk.locker.conn.KvProgPut(lk.locker.key(lk.name), skv.NewKvEntry(lk.token), &skv.KvProgWriteOptions{
+			PrevSum: crc32.ChecksumIEEE([]byte(lk.token)),
+			Expired: expired(lk.ttl),
+		})
+
+		if rs.OK() {
+			deadline = time.Now().Add(lk.ttl)
+			continue
+		}
+
+		// network errors are retried until the lease runs out, any other
+		// reply means the key is gone or owned by someone else
+		if !lk.strict &&
+			(rs.Status() == skv.ResultNetError || rs.Status() == skv.ResultTimeout) &&
+			time.Now().Add(interval).Before(deadline) {
+			continue
+		}
+
+		lk.mu.Lock()
+		if !lk.closed {
+			lk.closed = true
+			close(lk.done)
+		}
+		lk.mu.Unlock()
+		close(lk.lost)
+		return
+	}
+}
+
+func token_new() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+func expired(ttl time.Duration) uint64 {
+	return uint64(time.Now().Add(ttl).UnixNano())
+}
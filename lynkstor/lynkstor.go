@@ -15,9 +15,11 @@
 package lynkstor
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/hooto/hlog4g/hlog"
@@ -25,9 +27,19 @@ import (
 )
 
 type Connector struct {
-	clients chan *client
-	cfg     Config
-	copts   *connOptions
+	clients      chan *client
+	cfg          Config
+	copts        *connOptions
+	mget_off     int32
+	progmget_off int32
+	codec        atomic.Value // codecRef
+
+	// replaced by the Set functions while commands run
+	compress atomic.Value // compressRef
+	crypt    atomic.Value // *CryptKeyring
+	cache    atomic.Value // *cache
+
+	invoke Invoker
 }
 
 type connOptions struct {
@@ -35,6 +47,7 @@ type connOptions struct {
 	addr    string
 	timeout time.Duration
 	auth    string
+	dial    func(network, addr string) (net.Conn, error)
 }
 
 func NewConnector(cfg Config) (*Connector, error) {
@@ -60,6 +73,7 @@ func NewConnector(cfg Config) (*Connector, error) {
 	opts := &connOptions{
 		timeout: time.Duration(cfg.Timeout) * time.Second,
 		auth:    cfg.Auth,
+		dial:    cfg.Dialer,
 	}
 
 	if len(cfg.Socket) > 2 {
@@ -70,8 +84,10 @@ func NewConnector(cfg Config) (*Connector, error) {
 
 	if opts.net == "" {
 		opts.addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-		if _, err := net.ResolveTCPAddr("tcp", opts.addr); err != nil {
-			return nil, err
+		if opts.dial == nil {
+			if _, err := net.ResolveTCPAddr("tcp", opts.addr); err != nil {
+				return nil, err
+			}
 		}
 		opts.net = "tcp"
 	}
@@ -82,6 +98,22 @@ func NewConnector(cfg Config) (*Connector, error) {
 		copts:   opts,
 	}
 
+	c.invoke = chain(cfg.Interceptors, c.cmd)
+
+	if cfg.Codec != "" {
+		codec := codec_lookup(cfg.Codec)
+		if codec == nil {
+			return nil, errors.New("codec not found: " + cfg.Codec)
+		}
+		c.SetCodec(codec)
+	}
+
+	if algo, err := compress_lookup(cfg.Compress); err != nil {
+		return nil, err
+	} else {
+		c.SetCompress(algo, cfg.CompressThreshold)
+	}
+
 	for i := 0; i < cfg.MaxConn; i++ {
 		cli, err := newClient(c.copts, i)
 		if err != nil {
@@ -94,6 +126,18 @@ func NewConnector(cfg Config) (*Connector, error) {
 }
 
 func (c *Connector) Cmd(cmd string, args ...interface{}) skv.Result {
+	if len(c.cfg.Interceptors) == 0 {
+		return c.invoke(cmd, args...)
+	}
+	// results the interceptors built themselves are decrypted too
+	rs := result_of(c.invoke(cmd, args...))
+	if kr := c.keyring(); kr != nil {
+		rs.crypt_set(kr)
+	}
+	return rs
+}
+
+func (c *Connector) cmd(cmd string, args ...interface{}) skv.Result {
 
 	var (
 		cli, _ = c.pull()
@@ -119,9 +163,81 @@ func (c *Connector) Cmd(cmd string, args ...interface{}) skv.Result {
 
 	c.push(cli)
 
+	if kr := c.keyring(); kr != nil {
+		if v, ok := rs.(*Result); ok {
+			v.crypt_set(kr)
+		}
+	}
+
 	return rs
 }
 
+func (c *Connector) pipe(cmds []*cmdEntry) []*Result {
+	return c.pipe_tries(cmds, 3)
+}
+
+// pipe_tries sends cmds through one pooled connection, and again after a
+// network error up to tries times in all. A broken connection is replaced
+// before it goes back to the pool.
+func (c *Connector) pipe_tries(cmds []*cmdEntry, tries int) []*Result {
+	if len(c.cfg.Interceptors) > 0 {
+		return c.batch(cmds, tries)
+	}
+	return c.pipe_conn(cmds, tries)
+}
+
+func (c *Connector) pipe_conn(cmds []*cmdEntry, tries int) []*Result {
+
+	var (
+		cli, _ = c.pull()
+		rss    []*Result
+	)
+
+	for try := 1; try <= tries; try++ {
+
+		rss = cli.pipe(cmds)
+		if len(rss) == 0 || rss[0].status != skv.ResultNetError {
+			break
+		}
+
+		if try < tries {
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+
+		if cn, err := newClient(c.copts, cli.num); err == nil {
+			cli.Close()
+			cli = cn
+			hlog.Printf("info", "lynkdb/lynkstorgo reconnect %s://%s #%d",
+				c.copts.net, c.copts.addr, cli.num)
+		}
+	}
+
+	c.push(cli)
+
+	if kr := c.keyring(); kr != nil {
+		for _, v := range rss {
+			v.crypt_set(kr)
+		}
+	}
+
+	return rss
+}
+
+// SetCodec sets the default codec of structured values written through
+// this connector, nil restores the json encoding. It may be called while
+// other goroutines use the connector.
+func (c *Connector) SetCodec(codec Codec) {
+	c.codec.Store(codecRef{codec})
+}
+
+// Codec returns the default codec, nil for json.
+func (c *Connector) Codec() Codec {
+	if v, ok := c.codec.Load().(codecRef); ok {
+		return v.codec
+	}
+	return nil
+}
+
 func (c *Connector) Close() error {
 	for i := 0; i < c.cfg.MaxConn; i++ {
 		cli, _ := c.pull()
@@ -0,0 +1,348 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor/lynkstortest"
+)
+
+// echo_server replies to "echo <arg>" with arg, and to "bad" with a reply
+// that is not RESP. kvget and kvmget return "v:<key>" for every key but
+// those starting with "missing".
+func echo_server(t *testing.T) net.Listener {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go echo_serve(conn)
+		}
+	}()
+
+	return ln
+}
+
+func echo_serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	var (
+		r = bufio.NewReader(conn)
+		w = bufio.NewWriter(conn)
+	)
+
+	for {
+
+		args, err := echo_read(r)
+		if err != nil {
+			return
+		}
+
+		switch strings.ToLower(args[0]) {
+
+		case "echo":
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(args[1]), args[1])
+
+		case "bad":
+			io.WriteString(w, "?bad\r\n")
+
+		case "kvget":
+			echo_value(w, args[1])
+
+		case "kvmget":
+			fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				echo_value(w, key)
+			}
+
+		default:
+			io.WriteString(w, "-ERR unknown command\r\n")
+		}
+
+		// flush once the batch read so far is answered
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func echo_value(w io.Writer, key string) {
+	if strings.HasPrefix(key, "missing") {
+		io.WriteString(w, "$-1\r\n")
+	} else {
+		fmt.Fprintf(w, "$%d\r\nv:%s\r\n", len(key)+2, key)
+	}
+}
+
+func echo_read(r *bufio.Reader) ([]string, error) {
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' || n < 1 {
+		return nil, fmt.Errorf("bad request %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		bs := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		args[i] = string(bs[:size])
+	}
+
+	return args, nil
+}
+
+func test_connector(t *testing.T, px *lynkstortest.Proxy, interceptors ...Interceptor) *Connector {
+
+	ln := echo_server(t)
+
+	c, err := NewConnector(Config{
+		Host:         "127.0.0.1",
+		Port:         uint16(ln.Addr().(*net.TCPAddr).Port),
+		MaxConn:      1,
+		Dialer:       px.Dial,
+		Interceptors: interceptors,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		px.Close()
+	})
+
+	// shorter than the minimum of Config.Timeout
+	c.copts.timeout = 500 * time.Millisecond
+
+	return c
+}
+
+func echo_cmds(n int) []*cmdEntry {
+	cmds := make([]*cmdEntry, n)
+	for i := range cmds {
+		cmds[i] = &cmdEntry{"echo", []interface{}{fmt.Sprintf("value-%04d-%s", i, strings.Repeat("x", 64))}}
+	}
+	return cmds
+}
+
+func echo_check(t *testing.T, cmds []*cmdEntry, rss []*Result) {
+	if len(rss) != len(cmds) {
+		t.Fatalf("%d results of %d commands", len(rss), len(cmds))
+	}
+	for i, rs := range rss {
+		if !rs.OK() || string(rs.Bytes()) != cmds[i].args[0] {
+			t.Fatalf("result #%d: status %d, %q", i, rs.Status(), rs.Bytes())
+		}
+	}
+}
+
+func TestCmdRetry(t *testing.T) {
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+
+	px.Add(lynkstortest.Rule{Cmd: "echo", Fault: lynkstortest.FaultDrop, Times: 1})
+
+	rs := c.Cmd("echo", "hello")
+	if !rs.OK() || string(rs.Bytes()) != "hello" {
+		t.Fatalf("status %d, %q", rs.Status(), rs.Bytes())
+	}
+	if n := px.Count("echo"); n != 2 {
+		t.Fatalf("sent %d times, want 2", n)
+	}
+}
+
+func TestCmdTimeout(t *testing.T) {
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+
+	px.Add(lynkstortest.Rule{Cmd: "echo", Fault: lynkstortest.FaultTimeout, Times: 1})
+
+	if rs := c.Cmd("echo", "a"); rs.Status() != skv.ResultTimeout {
+		t.Fatalf("status %d, want timeout", rs.Status())
+	}
+	if n := px.Count("echo"); n != 1 {
+		t.Fatalf("sent %d times, a timeout is not retried", n)
+	}
+
+	if rs := c.Cmd("echo", "b"); !rs.OK() || string(rs.Bytes()) != "b" {
+		t.Fatalf("status %d, %q", rs.Status(), rs.Bytes())
+	}
+}
+
+func TestCmdTruncated(t *testing.T) {
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+
+	// the header of the bulk string arrives, its data never does
+	px.Add(lynkstortest.Rule{Cmd: "echo", Fault: lynkstortest.FaultTruncate, Bytes: 4, Times: 1})
+
+	if rs := c.Cmd("echo", "hello"); rs.Status() != skv.ResultTimeout {
+		t.Fatalf("status %d, want timeout", rs.Status())
+	}
+
+	// the broken connection is dropped, its tail is not read as the reply
+	// of the next command
+	for _, v := range []string{"one", "two"} {
+		if rs := c.Cmd("echo", v); !rs.OK() || string(rs.Bytes()) != v {
+			t.Fatalf("status %d, %q", rs.Status(), rs.Bytes())
+		}
+	}
+}
+
+func TestCmdParseError(t *testing.T) {
+
+	ln := echo_server(t)
+
+	cli, err := newClient(&connOptions{
+		net:     "tcp",
+		addr:    ln.Addr().String(),
+		timeout: time.Second,
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if rs := cli.cmd("bad"); rs.Status() != skv.ResultNetError {
+		t.Fatalf("status %d, want net error", rs.Status())
+	}
+	if cli.sock != nil {
+		t.Fatal("connection kept after a parse error")
+	}
+
+	if rs := cli.cmd("echo", "a"); !rs.OK() || string(rs.Bytes()) != "a" {
+		t.Fatalf("status %d, %q", rs.Status(), rs.Bytes())
+	}
+}
+
+func TestPipeRetry(t *testing.T) {
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+
+	cmds := echo_cmds(3)
+
+	px.Add(lynkstortest.Rule{Fault: lynkstortest.FaultDrop, Times: 1})
+	echo_check(t, cmds, c.pipe(cmds))
+	if n := px.Count("echo"); n != 4 {
+		t.Fatalf("sent %d commands, want 4", n)
+	}
+
+	px.Add(lynkstortest.Rule{Fault: lynkstortest.FaultDrop, Times: 1})
+	for i, rs := range c.pipe_tries(cmds, 1) {
+		if rs.Status() != skv.ResultNetError {
+			t.Fatalf("result #%d: status %d, want net error", i, rs.Status())
+		}
+	}
+}
+
+func TestPipeLarge(t *testing.T) {
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+
+	// many times the size of the read buffers
+	cmds := echo_cmds(2000)
+	echo_check(t, cmds, c.pipe(cmds))
+}
+
+func TestPipeInterceptors(t *testing.T) {
+
+	var (
+		mu      sync.Mutex
+		calls   = 0
+		nexts   = 0
+		pending = 0
+		max     = 0
+	)
+
+	counter := func(cmd string, args []interface{}, next Invoker) skv.Result {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		if args[0] == "own" {
+			return NewResult(skv.ResultOK, []byte("own"))
+		}
+		mu.Lock()
+		nexts++
+		pending++
+		if pending > max {
+			max = pending
+		}
+		mu.Unlock()
+		rs := next(cmd, args...)
+		mu.Lock()
+		pending--
+		mu.Unlock()
+		return rs
+	}
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px, counter)
+
+	cmds := append(echo_cmds(100), &cmdEntry{"echo", []interface{}{"own"}})
+	echo_check(t, cmds, c.pipe(cmds))
+
+	if calls != len(cmds) || nexts != len(cmds)-1 {
+		t.Fatalf("%d interceptor calls and %d to next", calls, nexts)
+	}
+
+	// the commands wait for each other at the end of the chain and go out
+	// as one pipeline
+	if max != nexts {
+		t.Fatalf("%d of %d commands were pipelined together", max, nexts)
+	}
+	if n := px.Count("echo"); n != nexts {
+		t.Fatalf("sent %d commands, want %d", n, nexts)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lynkstortest injects faults between a lynkstor connector and its
+// server, to test how callers handle a misbehaving server.
+//
+//	px := lynkstortest.NewProxy()
+//	px.Add(lynkstortest.Rule{Cmd: "kvget", Fault: lynkstortest.FaultDrop, Times: 1})
+//
+//	conn, err := lynkstor.NewConnector(lynkstor.Config{
+//		Host:   "127.0.0.1",
+//		Port:   6378,
+//		Dialer: px.Dial,
+//	})
+//
+// Every connection of the connector goes to the server through the proxy,
+// which reads one command, forwards it and returns its reply, unless a
+// rule of the command says otherwise. Streamed replies such as those of
+// watches are not supported.
+package lynkstortest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Fault uint8
+
+const (
+	// Forward the command, with the Delay of the rule only
+	FaultNone Fault = 0
+
+	// Close the connection after the first Bytes of the reply
+	FaultDrop Fault = 1
+
+	// Send only the first Bytes of the reply and keep the connection, the
+	// client waits for the rest or reads the next reply as its tail
+	FaultTruncate Fault = 2
+
+	// Reply -ERR Message without forwarding the command
+	FaultError Fault = 3
+
+	// Drop the command and never reply, the client runs into its timeout
+	FaultTimeout Fault = 4
+)
+
+var (
+	errProtocol = errors.New("protocol error")
+)
+
+type Rule struct {
+
+	// Command name, such as kvget. Leave blank to match every command
+	Cmd string
+
+	Fault Fault
+
+	// Wait before the command is handled
+	Delay time.Duration
+
+	// Bytes of the reply sent by FaultDrop and FaultTruncate, default to
+	// half of the reply
+	Bytes int
+
+	// Error message of FaultError, default to "injected fault"
+	Message string
+
+	// Number of commands the rule applies to, 0 for all
+	Times int
+
+	hits int
+}
+
+// Proxy is a dialer of connections to a server that injects faults into
+// the commands matched by its rules. The first matching rule of a command
+// applies.
+type Proxy struct {
+	mu    sync.Mutex
+	rules []*Rule
+	num   map[string]int
+	conns map[net.Conn]bool
+}
+
+func NewProxy() *Proxy {
+	return &Proxy{
+		num:   map[string]int{},
+		conns: map[net.Conn]bool{},
+	}
+}
+
+// Add appends a rule.
+func (p *Proxy) Add(r Rule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r.Cmd = strings.ToLower(r.Cmd)
+	p.rules = append(p.rules, &r)
+}
+
+// Clear removes all rules, the proxy forwards every command afterwards.
+func (p *Proxy) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = nil
+}
+
+// Count returns the number of commands named cmd the proxy has read,
+// including retries and commands answered by faults.
+func (p *Proxy) Count(cmd string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.num[strings.ToLower(cmd)]
+}
+
+// Dial connects to the server at addr and returns the client side of the
+// proxied connection. It matches the Dialer of lynkstor.Config.
+func (p *Proxy) Dial(network, addr string) (net.Conn, error) {
+
+	up, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// a loopback connection, unlike net.Pipe, buffers the writes of a
+	// pipelined batch while the proxy is still sending earlier replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		up.Close()
+		return nil, err
+	}
+	defer ln.Close()
+
+	cli, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		up.Close()
+		return nil, err
+	}
+
+	srv, err := ln.Accept()
+	if err != nil {
+		cli.Close()
+		up.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.conns[srv] = true
+	p.mu.Unlock()
+
+	go p.serve(srv, up)
+
+	return cli, nil
+}
+
+// Close closes all connections of the proxy.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for cn := range p.conns {
+		cn.Close()
+	}
+	return nil
+}
+
+func (p *Proxy) match(cmd string) Rule {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.num[cmd]++
+
+	for _, r := range p.rules {
+		if r.Cmd != "" && r.Cmd != cmd {
+			continue
+		}
+		if r.Times > 0 && r.hits >= r.Times {
+			continue
+		}
+		r.hits++
+		return *r
+	}
+
+	return Rule{}
+}
+
+type request struct {
+	name  []byte
+	frame []byte
+}
+
+func (p *Proxy) serve(cn, up net.Conn) {
+
+	var (
+		reqs = make(chan *request, 64)
+		done = make(chan struct{})
+	)
+
+	defer func() {
+		close(done)
+		p.mu.Lock()
+		delete(p.conns, cn)
+		p.mu.Unlock()
+		cn.Close()
+		up.Close()
+	}()
+
+	// requests are read on their own, so the client may send a whole batch
+	// before it reads any reply
+	go func() {
+		defer close(reqs)
+		cr := bufio.NewReader(cn)
+		for {
+			var buf bytes.Buffer
+			name, err := frame_read(cr, &buf)
+			if err != nil {
+				return
+			}
+			select {
+			case reqs <- &request{name, buf.Bytes()}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		ur  = bufio.NewReader(up)
+		rep bytes.Buffer
+	)
+
+	for req := range reqs {
+
+		r := p.match(strings.ToLower(string(req.name)))
+		if r.Delay > 0 {
+			time.Sleep(r.Delay)
+		}
+
+		switch r.Fault {
+
+		case FaultError:
+			if r.Message == "" {
+				r.Message = "injected fault"
+			}
+			if _, err := cn.Write([]byte("-ERR " + r.Message + "\r\n")); err != nil {
+				return
+			}
+			continue
+
+		case FaultTimeout:
+			continue
+		}
+
+		if _, err := up.Write(req.frame); err != nil {
+			return
+		}
+
+		rep.Reset()
+		if _, err := frame_read(ur, &rep); err != nil {
+			return
+		}
+		out := rep.Bytes()
+
+		if r.Fault == FaultDrop || r.Fault == FaultTruncate {
+			n := r.Bytes
+			if n < 1 || n >= len(out) {
+				n = len(out) / 2
+			}
+			out = out[:n]
+		}
+
+		if _, err := cn.Write(out); err != nil || r.Fault == FaultDrop {
+			return
+		}
+	}
+}
+
+// frame_read copies one RESP frame of r into buf. It returns the data of a
+// string frame, or of the first item of an array, which is the command name
+// of a request.
+func frame_read(r *bufio.Reader, buf *bytes.Buffer) ([]byte, error) {
+
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(line)
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+
+	case '+', '-', ':':
+		return line[1 : len(line)-2], nil
+
+	case '$', '*':
+		n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+		if err != nil {
+			return nil, errProtocol
+		}
+
+		if line[0] == '$' {
+			if n < 0 {
+				return nil, nil
+			}
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+			return data[:n], nil
+		}
+
+		var first []byte
+		for i := 0; i < n; i++ {
+			v, err := frame_read(r, buf)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				first = v
+			}
+		}
+		return first, nil
+	}
+
+	return nil, errProtocol
+}
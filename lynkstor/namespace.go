@@ -0,0 +1,392 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+var (
+	ns_name_reg = regexp.MustCompile(`^[a-zA-Z0-9_\-.]{1,50}$`)
+)
+
+// NamespaceConnector is a view of a Connector that keeps all keys inside
+// one namespace:
+//
+//	raw keys        ns:key
+//	prog keys       [ns, key...]
+//	pv paths        /ns/path
+//	file objects    /ns/path
+//
+// Scan results and watch events only hold keys of the namespace, with the
+// namespace removed. An empty raw key as the upper bound of a scan or watch
+// stands for the end of the namespace. An empty prog key stays invalid, so
+// prog scans and watches take a prefix of at least one item and can not
+// cover the whole view. Cmd, the Rep commands and the Set options act on
+// the whole server and are only offered by the Connector.
+type NamespaceConnector struct {
+	conn *Connector
+	ns   string
+	raw  []byte
+	path string
+}
+
+// Namespace returns a view of the connector for the namespace ns, which
+// may hold letters, digits and _ - . only.
+func (cn *Connector) Namespace(ns string) (*NamespaceConnector, error) {
+	if !ns_name_reg.MatchString(ns) {
+		return nil, errors.New("invalid namespace")
+	}
+	return &NamespaceConnector{
+		conn: cn,
+		ns:   ns,
+		raw:  []byte(ns + ":"),
+		path: "/" + ns,
+	}, nil
+}
+
+func (v *NamespaceConnector) key(key []byte) []byte {
+	return append(append([]byte{}, v.raw...), key...)
+}
+
+// key_end is key for the upper bound of a range, an empty one becomes the
+// first key after the namespace.
+func (v *NamespaceConnector) key_end(key []byte) []byte {
+	if len(key) == 0 {
+		return append([]byte(v.ns), ':'+1)
+	}
+	return v.key(key)
+}
+
+// prog_key returns [ns, key...], an empty key stays empty and invalid.
+func (v *NamespaceConnector) prog_key(key skv.KvProgKey) skv.KvProgKey {
+	if len(key.Items) == 0 {
+		return skv.KvProgKey{}
+	}
+	k := skv.NewKvProgKey(v.ns)
+	for _, item := range key.Items {
+		k.Items = append(k.Items, item)
+	}
+	return k
+}
+
+func (v *NamespaceConnector) fo_path(path string) string {
+	return v.path + "/" + pv_path_clean(path)
+}
+
+// ns_result keeps the scan entries accepted by fn and replaces their keys
+// with the ones fn returns.
+func ns_result(rs skv.Result, fn func(key []byte) ([]byte, bool)) skv.Result {
+
+	src, ok := rs.(*Result)
+	if !ok || len(src.items) < 2 {
+		return rs
+	}
+
+	dst := &Result{
+		status: src.status,
+		crypt:  src.crypt,
+	}
+
+	for i := 1; i < len(src.items); i += 2 {
+		if key, ok := fn(src.items[i-1].data); ok {
+			k := src.items[i-1].copy()
+			k.data = key
+			dst.items = append(dst.items, k, src.items[i])
+		}
+	}
+	dst.cap = len(dst.items)
+
+	if len(dst.items) == 0 {
+		dst.status = skv.ResultNotFound
+	}
+
+	return dst
+}
+
+func (v *NamespaceConnector) raw_strip(key []byte) ([]byte, bool) {
+	if bytes.HasPrefix(key, v.raw) {
+		return key[len(v.raw):], true
+	}
+	return nil, false
+}
+
+// prog_strip removes the first key item and encodes the others again.
+func (v *NamespaceConnector) prog_strip(key []byte) ([]byte, bool) {
+	k := skv.ProgKeyDecode(key)
+	if k == nil || len(k.Items) < 2 || string(k.Items[0].Data) != v.ns {
+		return nil, false
+	}
+	k.Items = k.Items[1:]
+	return k.Encode(key[0]), true
+}
+
+func (v *NamespaceConnector) fo_strip(key []byte) ([]byte, bool) {
+	if bytes.HasPrefix(key, []byte(v.path+"/")) {
+		return key[len(v.path):], true
+	}
+	return nil, false
+}
+
+// Codec returns the default codec of the underlying connector.
+func (v *NamespaceConnector) Codec() Codec {
+	return v.conn.Codec()
+}
+
+func (v *NamespaceConnector) KvNew(key []byte, value interface{}, opts *skv.KvWriteOptions) skv.Result {
+	return v.conn.KvNew(v.key(key), value, opts)
+}
+
+func (v *NamespaceConnector) KvPut(key []byte, value interface{}, opts *skv.KvWriteOptions) skv.Result {
+	return v.conn.KvPut(v.key(key), value, opts)
+}
+
+func (v *NamespaceConnector) KvGet(key []byte) skv.Result {
+	return v.conn.KvGet(v.key(key))
+}
+
+func (v *NamespaceConnector) KvMGet(keys ...[]byte) skv.Result {
+	ks := [][]byte{}
+	for _, key := range keys {
+		ks = append(ks, v.key(key))
+	}
+	return v.conn.KvMGet(ks...)
+}
+
+func (v *NamespaceConnector) KvDel(keys ...[]byte) skv.Result {
+	ks := [][]byte{}
+	for _, key := range keys {
+		ks = append(ks, v.key(key))
+	}
+	return v.conn.KvDel(ks...)
+}
+
+func (v *NamespaceConnector) KvScan(offset, cutset []byte, limit int) skv.Result {
+	return ns_result(v.conn.KvScan(v.key(offset), v.key_end(cutset), limit), v.raw_strip)
+}
+
+func (v *NamespaceConnector) KvRevScan(offset, cutset []byte, limit int) skv.Result {
+	return ns_result(v.conn.KvRevScan(v.key_end(offset), v.key(cutset), limit), v.raw_strip)
+}
+
+func (v *NamespaceConnector) KvIncr(key []byte, increment int64) skv.Result {
+	return v.conn.KvIncr(v.key(key), increment)
+}
+
+func (v *NamespaceConnector) KvMeta(key []byte) skv.Result {
+	return v.conn.KvMeta(v.key(key))
+}
+
+func (v *NamespaceConnector) KvExpire(key []byte, ttl time.Duration) skv.Result {
+	return v.conn.KvExpire(v.key(key), ttl)
+}
+
+func (v *NamespaceConnector) KvPersist(key []byte) skv.Result {
+	return v.conn.KvPersist(v.key(key))
+}
+
+func (v *NamespaceConnector) KvTTL(key []byte) (time.Duration, error) {
+	return v.conn.KvTTL(v.key(key))
+}
+
+func (v *NamespaceConnector) KvProgNew(key skv.KvProgKey, val skv.KvEntry, opts *skv.KvProgWriteOptions) skv.Result {
+	return v.conn.KvProgNew(v.prog_key(key), val, opts)
+}
+
+func (v *NamespaceConnector) KvProgPut(key skv.KvProgKey, val skv.KvEntry, opts *skv.KvProgWriteOptions) skv.Result {
+	return v.conn.KvProgPut(v.prog_key(key), val, opts)
+}
+
+func (v *NamespaceConnector) KvProgGet(key skv.KvProgKey) skv.Result {
+	return v.conn.KvProgGet(v.prog_key(key))
+}
+
+func (v *NamespaceConnector) KvProgMGet(keys ...skv.KvProgKey) skv.Result {
+	ks := []skv.KvProgKey{}
+	for _, key := range keys {
+		ks = append(ks, v.prog_key(key))
+	}
+	return v.conn.KvProgMGet(ks...)
+}
+
+func (v *NamespaceConnector) KvProgDel(key skv.KvProgKey, opts *skv.KvProgWriteOptions) skv.Result {
+	return v.conn.KvProgDel(v.prog_key(key), opts)
+}
+
+func (v *NamespaceConnector) KvProgScan(offset, cutset skv.KvProgKey, limit int) skv.Result {
+	return ns_result(v.conn.KvProgScan(v.prog_key(offset), v.prog_key(cutset), limit), v.prog_strip)
+}
+
+func (v *NamespaceConnector) KvProgRevScan(offset, cutset skv.KvProgKey, limit int) skv.Result {
+	return ns_result(v.conn.KvProgRevScan(v.prog_key(offset), v.prog_key(cutset), limit), v.prog_strip)
+}
+
+func (v *NamespaceConnector) KvProgIncr(key skv.KvProgKey, incr int64) skv.Result {
+	return v.conn.KvProgIncr(v.prog_key(key), incr)
+}
+
+func (v *NamespaceConnector) KvProgMeta(key skv.KvProgKey) skv.Result {
+	return v.conn.KvProgMeta(v.prog_key(key))
+}
+
+func (v *NamespaceConnector) KvProgExpire(key skv.KvProgKey, ttl time.Duration) skv.Result {
+	return v.conn.KvProgExpire(v.prog_key(key), ttl)
+}
+
+func (v *NamespaceConnector) KvProgPersist(key skv.KvProgKey) skv.Result {
+	return v.conn.KvProgPersist(v.prog_key(key))
+}
+
+func (v *NamespaceConnector) KvProgTTL(key skv.KvProgKey) (time.Duration, error) {
+	return v.conn.KvProgTTL(v.prog_key(key))
+}
+
+func (v *NamespaceConnector) PvNew(path string, value interface{}, opts *skv.KvProgWriteOptions) skv.Result {
+	entry, err := v.conn.kv_entry(value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	return v.KvProgNew(pv_path_parser(path), entry, opts)
+}
+
+func (v *NamespaceConnector) PvDel(path string, opts *skv.KvProgWriteOptions) skv.Result {
+	return v.KvProgDel(pv_path_parser(path), opts)
+}
+
+func (v *NamespaceConnector) PvPut(path string, value interface{}, opts *skv.KvProgWriteOptions) skv.Result {
+	entry, err := v.conn.kv_entry(value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	return v.KvProgPut(pv_path_parser(path), entry, opts)
+}
+
+func (v *NamespaceConnector) PvGet(path string) skv.Result {
+	return v.KvProgGet(pv_path_parser(path))
+}
+
+func (v *NamespaceConnector) PvScan(fold, offset, cutset string, limit int) skv.Result {
+	return v.KvProgScan(pv_path_parser_add(fold, offset), pv_path_parser_add(fold, cutset), limit)
+}
+
+func (v *NamespaceConnector) PvRevScan(fold, offset, cutset string, limit int) skv.Result {
+	return v.KvProgRevScan(pv_path_parser_add(fold, offset), pv_path_parser_add(fold, cutset), limit)
+}
+
+func (v *NamespaceConnector) FoMpInit(sets skv.FileObjectEntryInit) skv.Result {
+	sets.Path = v.fo_path(sets.Path)
+	return v.conn.FoMpInit(sets)
+}
+
+func (v *NamespaceConnector) FoMpPut(sets skv.FileObjectEntryBlock) skv.Result {
+	sets.Path = v.fo_path(sets.Path)
+	return v.conn.FoMpPut(sets)
+}
+
+func (v *NamespaceConnector) FoMpGet(sets skv.FileObjectEntryBlock) skv.Result {
+	sets.Path = v.fo_path(sets.Path)
+	return v.conn.FoMpGet(sets)
+}
+
+func (v *NamespaceConnector) FoGet(path string) skv.Result {
+	return v.conn.FoGet(v.fo_path(path))
+}
+
+func (v *NamespaceConnector) FoScan(offset, cutset string, limit int) skv.Result {
+	return ns_result(v.conn.FoScan(v.fo_path(offset), v.fo_path(cutset), limit), v.fo_strip)
+}
+
+func (v *NamespaceConnector) FoRevScan(offset, cutset string, limit int) skv.Result {
+	return ns_result(v.conn.FoRevScan(v.fo_path(offset), v.fo_path(cutset), limit), v.fo_strip)
+}
+
+func (v *NamespaceConnector) FoDel(path string) skv.Result {
+	return v.conn.FoDel(v.fo_path(path))
+}
+
+func (v *NamespaceConnector) FoFilePut(src_path, dst_path string) skv.Result {
+	return v.conn.FoFilePut(src_path, v.fo_path(dst_path))
+}
+
+func (v *NamespaceConnector) FoFilePutProgress(src_path, dst_path string, progress func(done, total int64)) skv.Result {
+	return v.conn.FoFilePutProgress(src_path, v.fo_path(dst_path), progress)
+}
+
+func (v *NamespaceConnector) FoBlockSums(path string) (uint64, []uint32, error) {
+	return v.conn.FoBlockSums(v.fo_path(path))
+}
+
+func (v *NamespaceConnector) FoFileOpen(path string) (io.ReadSeeker, error) {
+	return v.conn.FoFileOpen(v.fo_path(path))
+}
+
+func (v *NamespaceConnector) Watch(ctx context.Context, prefix skv.KvProgKey) (<-chan *WatchEvent, error) {
+	ch, err := v.conn.Watch(ctx, v.prog_key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	return ns_events(ctx, ch, v.prog_strip), nil
+}
+
+func (v *NamespaceConnector) KvWatch(ctx context.Context, offset, cutset []byte) (<-chan *WatchEvent, error) {
+	ch, err := v.conn.KvWatch(ctx, v.key(offset), v.key_end(cutset))
+	if err != nil {
+		return nil, err
+	}
+	return ns_events(ctx, ch, v.raw_strip), nil
+}
+
+// ns_events passes on the events of src with the keys fn accepts, replaced
+// by the ones it returns.
+func ns_events(ctx context.Context, src <-chan *WatchEvent, fn func(key []byte) ([]byte, bool)) <-chan *WatchEvent {
+
+	dst := make(chan *WatchEvent, cap(src))
+
+	go func() {
+		defer close(dst)
+		for ev := range src {
+			if ev.Type != WatchEventError {
+				key, ok := fn(ev.Key)
+				if !ok {
+					continue
+				}
+				ev.Key = key
+			}
+			select {
+			case dst <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return dst
+}
+
+func (v *NamespaceConnector) NewBulkWriter(opts BulkOptions) *BulkWriter {
+	return bulk_writer_new(v.conn, opts, v.key)
+}
+
+// Close is a no-op, the underlying Connector is closed by its owner.
+func (v *NamespaceConnector) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+func TestNamespaceStrip(t *testing.T) {
+
+	v, err := (&Connector{}).Namespace("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog := func(items ...interface{}) []byte {
+		k := skv.NewKvProgKey(items...)
+		return k.Encode(7)
+	}
+
+	tests := []struct {
+		name  string
+		strip func(key []byte) ([]byte, bool)
+		key   []byte
+		want  []byte // nil if the key is outside the namespace
+	}{
+		{"raw", v.raw_strip, []byte("ns:key"), []byte("key")},
+		{"raw empty", v.raw_strip, []byte("ns:"), []byte{}},
+		{"raw other", v.raw_strip, []byte("nsx:key"), nil},
+		{"raw inner", v.raw_strip, []byte("a:ns:key"), nil},
+
+		{"prog", v.prog_strip, prog("ns", "a", "b"), prog("a", "b")},
+		{"prog item like ns", v.prog_strip, prog("ns", "a", "ns", "b"), prog("a", "ns", "b")},
+		{"prog other", v.prog_strip, prog("other", "ns", "a"), nil},
+		{"prog ns only", v.prog_strip, prog("ns"), nil},
+
+		{"fo", v.fo_strip, []byte("/ns/a/b"), []byte("/a/b")},
+		{"fo other", v.fo_strip, []byte("/nsx/a"), nil},
+		{"fo inner", v.fo_strip, []byte("/other/ns/a"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := tt.strip(tt.key)
+			if ok != (tt.want != nil) {
+				t.Fatalf("ok %v for %q", ok, tt.key)
+			}
+			if ok && !bytes.Equal(key, tt.want) {
+				t.Fatalf("stripped %q, want %q", key, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceKeys(t *testing.T) {
+
+	v, _ := (&Connector{}).Namespace("ns")
+
+	if got := v.key_end(nil); !bytes.Equal(got, []byte("ns;")) {
+		t.Fatalf("end of namespace %q", got)
+	}
+	for _, key := range [][]byte{[]byte("ns:"), []byte("ns:\xff\xff"), v.key([]byte("zzz"))} {
+		if bytes.Compare(key, v.key_end(nil)) >= 0 {
+			t.Fatalf("%q after the end of the namespace", key)
+		}
+	}
+	if got := v.key_end([]byte("k")); !bytes.Equal(got, []byte("ns:k")) {
+		t.Fatalf("cutset %q", got)
+	}
+
+	if k := v.prog_key(skv.KvProgKey{}); len(k.Items) != 0 {
+		t.Fatal("empty prog key moved into the namespace")
+	}
+	if got := v.fo_path("a/b"); got != "/ns/a/b" {
+		t.Fatalf("fo path %q", got)
+	}
+
+	for _, ns := range []string{"", "a/b", "a:b", string(make([]byte, 51))} {
+		if _, err := (&Connector{}).Namespace(ns); err == nil {
+			t.Fatalf("namespace %q accepted", ns)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue implements a durable work queue on ordered prog keys.
+//
+// Under the configured prefix a queue keeps
+//
+//	j, id                 the message record
+//	r, priority.seq.id    ready messages, in dequeue order
+//	d, due.id             delayed messages, moved to r once due
+//	c, id                 the claim of a dequeued message, with a TTL of
+//	                      the visibility timeout
+//	x, id                 dead letters
+//
+// A dequeued message stays in r while it is claimed, so it becomes visible
+// again as soon as its claim expires. Delivery is at least once.
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+var (
+	ErrEmpty = errors.New("queue is empty")
+
+	scan_limit = 100
+)
+
+type Options struct {
+
+	// Prog key prefix of all queue keys
+	Prefix skv.KvProgKey
+
+	// Time a dequeued message stays invisible before it is delivered again,
+	// default to 30 seconds
+	Visibility time.Duration
+
+	// Number of deliveries before a message is moved to the dead letters,
+	// default to 5
+	MaxAttempts int
+}
+
+type EnqueueOptions struct {
+
+	// Messages with a lower priority value are dequeued first
+	Priority uint8
+
+	// Time before the message becomes visible
+	Delay time.Duration
+}
+
+type Message struct {
+	ID       string `json:"id"`
+	Data     []byte `json:"data"`
+	Priority uint8  `json:"priority"`
+	Attempts int    `json:"attempts"`
+	Seq      int64  `json:"seq"`
+	Created  int64  `json:"created"`
+	token    string
+}
+
+type Queue struct {
+	conn skv.Connector
+	opts Options
+}
+
+func New(conn skv.Connector, opts Options) (*Queue, error) {
+	if !opts.Prefix.Valid() {
+		return nil, errors.New("invalid prefix")
+	}
+	if opts.Visibility < time.Second {
+		opts.Visibility = 30 * time.Second
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 5
+	}
+	return &Queue{
+		conn: conn,
+		opts: opts,
+	}, nil
+}
+
+func (q *Queue) key(values ...interface{}) skv.KvProgKey {
+	k := skv.KvProgKey{}
+	for _, v := range q.opts.Prefix.Items {
+		k.Append(v.Data)
+	}
+	for _, v := range values {
+		k.Append(v)
+	}
+	return k
+}
+
+func ready_name(m *Message) string {
+	return fmt.Sprintf("%03d%020d%s", m.Priority, m.Seq, m.ID)
+}
+
+func delay_name(due int64, id string) string {
+	return fmt.Sprintf("%020d%s", due, id)
+}
+
+// Enqueue adds a message and returns its id.
+func (q *Queue) Enqueue(data []byte, opts *EnqueueOptions) (string, error) {
+
+	if opts == nil {
+		opts = &EnqueueOptions{}
+	}
+
+	id, err := rand_hex(8)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UnixNano()
+	m := &Message{
+		ID:       fmt.Sprintf("%016x", now) + id,
+		Data:     data,
+		Priority: opts.Priority,
+		Seq:      now,
+		Created:  now,
+	}
+
+	if err := q.put(q.key("j", m.ID), m); err != nil {
+		return "", err
+	}
+
+	if opts.Delay > 0 {
+		err = q.put(q.key("d", delay_name(now+int64(opts.Delay), m.ID)), m.ID)
+	} else {
+		err = q.put(q.key("r", ready_name(m)), m.ID)
+	}
+
+	return m.ID, err
+}
+
+// Dequeue claims the next visible message. The message must be passed to
+// Ack or Nack before the visibility timeout ends.
+func (q *Queue) Dequeue() (*Message, error) {
+
+	if err := q.promote(); err != nil {
+		return nil, err
+	}
+
+	token, err := rand_hex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Message
+
+	err = q.scan("r", func(name, id string) (bool, error) {
+
+		if !q.claim(id, token) {
+			return true, nil
+		}
+
+		msg, err := q.get(id)
+		if err == ErrEmpty {
+			// acked by another consumer after our scan
+			q.del(q.key("r", name), q.key("c", id))
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		msg.Attempts++
+		msg.token = token
+
+		if msg.Attempts > q.opts.MaxAttempts {
+			if err := q.put(q.key("x", id), id); err != nil {
+				return false, err
+			}
+			q.del(q.key("r", name), q.key("c", id))
+			return true, nil
+		}
+
+		if err := q.put(q.key("j", id), msg); err != nil {
+			return false, err
+		}
+
+		m = msg
+		return false, nil
+	})
+
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		return nil, ErrEmpty
+	}
+
+	return m, nil
+}
+
+// Ack removes a dequeued message from the queue.
+func (q *Queue) Ack(m *Message) error {
+	if !q.owned(m) {
+		return errors.New("message claim lost")
+	}
+	return q.del(q.key("r", ready_name(m)), q.key("j", m.ID), q.key("c", m.ID))
+}
+
+// Nack returns a dequeued message to the queue after delay.
+func (q *Queue) Nack(m *Message, delay time.Duration) error {
+
+	if !q.owned(m) {
+		return errors.New("message claim lost")
+	}
+
+	if delay > 0 {
+		due := time.Now().UnixNano() + int64(delay)
+		if err := q.put(q.key("d", delay_name(due, m.ID)), m.ID); err != nil {
+			return err
+		}
+		if err := q.del(q.key("r", ready_name(m))); err != nil {
+			return err
+		}
+	}
+
+	return q.del(q.key("c", m.ID))
+}
+
+// Peek returns the next visible message without claiming it.
+func (q *Queue) Peek() (*Message, error) {
+
+	var m *Message
+
+	err := q.scan("r", func(name, id string) (bool, error) {
+		if rs := q.conn.KvProgGet(q.key("c", id)); rs.OK() {
+			return true, nil
+		}
+		msg, err := q.get(id)
+		if err == ErrEmpty {
+			return true, nil
+		}
+		m = msg
+		return false, err
+	})
+
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		return nil, ErrEmpty
+	}
+
+	return m, nil
+}
+
+// Len returns the number of ready, claimed and delayed messages.
+func (q *Queue) Len() (int, error) {
+	num := 0
+	for _, sub := range []string{"r", "d"} {
+		if err := q.scan(sub, func(name, id string) (bool, error) {
+			num++
+			return true, nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return num, nil
+}
+
+// DeadLetters returns up to limit messages that exceeded MaxAttempts.
+func (q *Queue) DeadLetters(limit int) ([]*Message, error) {
+	ls := []*Message{}
+	err := q.scan("x", func(name, id string) (bool, error) {
+		if m, err := q.get(id); err == nil {
+			ls = append(ls, m)
+		} else if err != ErrEmpty {
+			return false, err
+		}
+		return len(ls) < limit, nil
+	})
+	return ls, err
+}
+
+// promote moves the delayed messages that are due to the ready index.
+func (q *Queue) promote() error {
+
+	now := delay_name(time.Now().UnixNano(), "")
+
+	return q.scan("d", func(name, id string) (bool, error) {
+		if name > now {
+			return false, nil
+		}
+		m, err := q.get(id)
+		if err == ErrEmpty {
+			return true, q.del(q.key("d", name))
+		} else if err != nil {
+			return false, err
+		}
+		if err := q.put(q.key("r", ready_name(m)), m.ID); err != nil {
+			return false, err
+		}
+		return true, q.del(q.key("d", name))
+	})
+}
+
+func (q *Queue) claim(id, token string) bool {
+
+	key := q.key("c", id)
+	rs := q.conn.KvProgNew(key, skv.NewKvEntry(token), &skv.KvProgWriteOptions{
+		Expired: uint64(time.Now().Add(q.opts.Visibility).UnixNano()),
+	})
+	switch rs.Status() {
+	case skv.ResultNetError, skv.ResultTimeout, skv.ResultNoAuth, skv.ResultBadArgument:
+		return false
+	}
+
+	// KvProgNew keeps an existing key, read it back to see who owns it
+	rs = q.conn.KvProgGet(key)
+	return rs.OK() && rs.String() == token
+}
+
+func (q *Queue) owned(m *Message) bool {
+	if m == nil || m.token == "" {
+		return false
+	}
+	rs := q.conn.KvProgGet(q.key("c", m.ID))
+	return rs.OK() && rs.String() == m.token
+}
+
+func (q *Queue) get(id string) (*Message, error) {
+	rs := q.conn.KvProgGet(q.key("j", id))
+	if rs.NotFound() {
+		return nil, ErrEmpty
+	} else if !rs.OK() {
+		return nil, errors.New(rs.ErrorString())
+	}
+	var m Message
+	if err := rs.Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (q *Queue) put(key skv.KvProgKey, value interface{}) error {
+	if rs := q.conn.KvProgPut(key, skv.NewKvEntry(value), nil); !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+	return nil
+}
+
+func (q *Queue) del(keys ...skv.KvProgKey) error {
+	for _, key := range keys {
+		if rs := q.conn.KvProgDel(key, nil); !rs.OK() && !rs.NotFound() {
+			return errors.New(rs.ErrorString())
+		}
+	}
+	return nil
+}
+
+// scan calls fn with the name and message id of every entry of the index
+// sub, in key order, until fn returns false.
+func (q *Queue) scan(sub string, fn func(name, id string) (bool, error)) error {
+
+	var (
+		cutset = q.key(sub, "")
+		offset = cutset
+		last   = ""
+	)
+
+	for {
+
+		rs := q.conn.KvProgScan(offset, cutset, scan_limit)
+		if rs.NotFound() {
+			return nil
+		} else if !rs.OK() {
+			return errors.New(rs.ErrorString())
+		}
+
+		ls := rs.KvPairs()
+		for _, v := range ls {
+
+			k := skv.ProgKeyDecode(v.KvKey())
+			if k == nil || len(k.Items) < 1 {
+				continue
+			}
+
+			name := string(k.Items[len(k.Items)-1].Data)
+			if name == last {
+				continue
+			}
+			last = name
+
+			if next, err := fn(name, v.String()); err != nil || !next {
+				return err
+			}
+		}
+
+		if len(ls) < scan_limit {
+			return nil
+		}
+
+		offset = q.key(sub, last)
+	}
+}
+
+func rand_hex(n int) (string, error) {
+	bs := make([]byte, n)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
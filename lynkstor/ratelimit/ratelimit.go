@@ -0,0 +1,243 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit implements fleet wide rate limiters on KvIncr.
+//
+// Every window has its own counter key [prefix:id:window], so replicas never
+// race on creating or resetting a counter. The replica that creates a
+// counter sets its expiry, and retries it on its next request of the same
+// counter if it failed, until the counter is no longer read two windows
+// later. Counters on a server without the expire command are never removed.
+// Denied requests are counted too.
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+// Connector is the subset of lynkstor.Connector used by the limiters.
+type Connector interface {
+	KvGet(key []byte) skv.Result
+	KvIncr(key []byte, increment int64) skv.Result
+	KvExpire(key []byte, ttl time.Duration) skv.Result
+}
+
+type Options struct {
+
+	// Maximum number of requests per window
+	Limit int64
+
+	// Length of a window
+	Window time.Duration
+
+	// Key prefix of the counters, default to "ratelimit"
+	Prefix string
+
+	// Allow requests when the connector is unavailable, otherwise deny them
+	// and return the error
+	FailOpen bool
+}
+
+type Result struct {
+	Allowed    bool
+	Remaining  int64
+	ResetAfter time.Duration
+
+	// The error of the connector, for a request allowed by FailOpen
+	Err error
+}
+
+type limiter struct {
+	conn Connector
+	opts Options
+
+	mu     *sync.Mutex
+	expire map[string]int64 // window of the counters whose expiry failed
+	pruned int64            // window of the last removal of old entries
+}
+
+// FixedWindow counts requests in consecutive windows of Options.Window.
+type FixedWindow struct {
+	limiter
+}
+
+// SlidingWindow weights the count of the previous window by its overlap
+// with a window that ends now, which smooths the bursts a FixedWindow
+// allows around window boundaries.
+type SlidingWindow struct {
+	limiter
+}
+
+func NewFixedWindow(conn Connector, opts Options) (*FixedWindow, error) {
+	l, err := limiter_new(conn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &FixedWindow{l}, nil
+}
+
+func NewSlidingWindow(conn Connector, opts Options) (*SlidingWindow, error) {
+	l, err := limiter_new(conn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SlidingWindow{l}, nil
+}
+
+func limiter_new(conn Connector, opts Options) (limiter, error) {
+	if opts.Limit < 1 || opts.Window < time.Second {
+		return limiter{}, errors.New("invalid limit or window")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "ratelimit"
+	}
+	return limiter{
+		conn:   conn,
+		opts:   opts,
+		mu:     &sync.Mutex{},
+		expire: map[string]int64{},
+	}, nil
+}
+
+func (l *FixedWindow) Allow(id string) (Result, error) {
+	return l.AllowN(id, 1)
+}
+
+func (l *FixedWindow) AllowN(id string, n int64) (Result, error) {
+
+	now := time.Now()
+	win, reset := l.window(now)
+
+	num, err := l.incr(id, win, n)
+	if err != nil {
+		return l.fail(reset, err)
+	}
+
+	return l.result(float64(num), reset), nil
+}
+
+func (l *SlidingWindow) Allow(id string) (Result, error) {
+	return l.AllowN(id, 1)
+}
+
+func (l *SlidingWindow) AllowN(id string, n int64) (Result, error) {
+
+	now := time.Now()
+	win, reset := l.window(now)
+
+	num, err := l.incr(id, win, n)
+	if err != nil {
+		return l.fail(reset, err)
+	}
+
+	rs := l.conn.KvGet(l.key(id, win-1))
+	if !rs.OK() && !rs.NotFound() {
+		return l.fail(reset, errors.New(rs.ErrorString()))
+	}
+
+	var (
+		overlap = float64(reset) / float64(l.opts.Window)
+		est     = float64(rs.Int64())*overlap + float64(num)
+	)
+
+	return l.result(est, reset), nil
+}
+
+// window returns the index of the window that contains t and the time
+// left until it ends.
+func (l *limiter) window(t time.Time) (int64, time.Duration) {
+	var (
+		ns  = t.UnixNano()
+		win = int64(l.opts.Window)
+	)
+	return ns / win, time.Duration(win - ns%win)
+}
+
+func (l *limiter) key(id string, win int64) []byte {
+	return []byte(l.opts.Prefix + ":" + id + ":" + strconv.FormatInt(win, 10))
+}
+
+func (l *limiter) incr(id string, win, n int64) (int64, error) {
+
+	key := l.key(id, win)
+
+	rs := l.conn.KvIncr(key, n)
+	if !rs.OK() {
+		return 0, errors.New(rs.ErrorString())
+	}
+
+	num := rs.Int64()
+
+	l.mu.Lock()
+	_, retry := l.expire[string(key)]
+	l.mu.Unlock()
+
+	if num == n || retry {
+		// the previous window is read by SlidingWindow, keep both
+		ok := l.conn.KvExpire(key, 2*l.opts.Window).OK()
+		l.mu.Lock()
+		if ok {
+			delete(l.expire, string(key))
+		} else {
+			l.expire[string(key)] = win
+			if win > l.pruned {
+				l.expire_prune(win)
+			}
+		}
+		l.mu.Unlock()
+	}
+
+	return num, nil
+}
+
+// expire_prune forgets the counters of windows before the previous one,
+// which are no longer read.
+func (l *limiter) expire_prune(win int64) {
+	for k, w := range l.expire {
+		if w < win-1 {
+			delete(l.expire, k)
+		}
+	}
+	l.pruned = win
+}
+
+func (l *limiter) result(num float64, reset time.Duration) Result {
+	rs := Result{
+		Allowed:    num <= float64(l.opts.Limit),
+		Remaining:  l.opts.Limit - int64(num),
+		ResetAfter: reset,
+	}
+	if rs.Remaining < 0 {
+		rs.Remaining = 0
+	}
+	return rs
+}
+
+// fail returns the result of a request the connector could not count, the
+// error is returned unless FailOpen allows the request.
+func (l *limiter) fail(reset time.Duration, err error) (Result, error) {
+	rs := Result{
+		ResetAfter: reset,
+	}
+	if !l.opts.FailOpen {
+		return rs, err
+	}
+	rs.Allowed, rs.Remaining, rs.Err = true, l.opts.Limit, err
+	return rs, nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hooto/hlog4g/hlog"
+	"github.com/lynkdb/iomix/skv"
+)
+
+// RepEntry is an entry as the replication commands move it, the internal
+// key of the server with the meta and the value bytes as stored. Values
+// keep their codec, compression and encryption.
+type RepEntry struct {
+	Key   []byte
+	Meta  *skv.KvMeta
+	Value []byte
+}
+
+// Deleted reports whether a log entry removes its key.
+func (e *RepEntry) Deleted() bool {
+	return len(e.Value) == 0
+}
+
+// RepEntryEncode returns the stored form of an entry, 0x01, len(meta),
+// meta, value.
+func RepEntryEncode(meta *skv.KvMeta, value []byte) ([]byte, error) {
+
+	if meta == nil {
+		meta = &skv.KvMeta{}
+	}
+
+	bs, err := proto.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) > 255 {
+		return nil, errors.New("meta too large")
+	}
+
+	enc := make([]byte, 0, 2+len(bs)+len(value))
+	enc = append(enc, kvobj_t_v1, uint8(len(bs)))
+	enc = append(enc, bs...)
+
+	return append(enc, value...), nil
+}
+
+// RepEntryDecode splits the stored form of an entry into its meta and
+// value. Data without the entry header is returned as the value.
+func RepEntryDecode(data []byte) (*skv.KvMeta, []byte) {
+	if meta, value, ok := entry_split(data); ok {
+		return skv.KvMetaDecode(meta), value
+	}
+	return nil, data
+}
+
+func entry_split(data []byte) ([]byte, []byte, bool) {
+	if len(data) > 1 && data[0] == kvobj_t_v1 {
+		offset := int(data[1]) + 2
+		if offset <= len(data) {
+			return data[2:offset], data[offset:], true
+		}
+	}
+	return nil, nil, false
+}
+
+func rep_entry(key, data []byte) *RepEntry {
+	meta, value := RepEntryDecode(data)
+	return &RepEntry{
+		Key:   key,
+		Meta:  meta,
+		Value: value,
+	}
+}
+
+func rep_entries(rs skv.Result) ([]*RepEntry, error) {
+	if rs.NotFound() {
+		return nil, nil
+	} else if !rs.OK() {
+		return nil, errors.New(rs.ErrorString())
+	}
+	ls := []*RepEntry{}
+	for _, v := range KvListRaw(rs) {
+		ls = append(ls, rep_entry(v.Key, v.Value))
+	}
+	return ls, nil
+}
+
+// RepPut writes an entry by its internal key. The local read cache is not
+// updated, since it is indexed by client keys.
+func (cn *Connector) RepPut(e *RepEntry) skv.Result {
+	if len(e.Key) == 0 || len(e.Value) == 0 {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+	enc, err := RepEntryEncode(e.Meta, e.Value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	return cn.Cmd("rep_data_put", e.Key, enc)
+}
+
+// RepGet returns the entry of an internal key, or ErrNotFound.
+func (cn *Connector) RepGet(key []byte) (*RepEntry, error) {
+	rs, ok := cn.Cmd("rep_data_get", key).(*Result)
+	if !ok {
+		return nil, errors.New("protocol error")
+	}
+	if rs.NotFound() {
+		return nil, ErrNotFound
+	} else if !rs.OK() {
+		return nil, errors.New(rs.ErrorString())
+	}
+	return rep_entry(key, rs.data), nil
+}
+
+// RepDel removes the entry of an internal key.
+func (cn *Connector) RepDel(key []byte) skv.Result {
+	return cn.Cmd("rep_data_del", key)
+}
+
+// RepScan returns the entries of the internal key range offset to cutset.
+func (cn *Connector) RepScan(offset, cutset []byte, limit int) ([]*RepEntry, error) {
+	return rep_entries(cn.Cmd("rep_data_scan", offset, cutset, limit))
+}
+
+// RepLog returns up to limit entries of the write log after the version
+// offset, in version order. Meta.Version of an entry is its log position,
+// deleted keys come with an empty value.
+func (cn *Connector) RepLog(offset uint64, limit int) ([]*RepEntry, error) {
+	return rep_entries(cn.Cmd("rep_log_scan", offset, limit))
+}
+
+// RepApply writes a log entry of another server to this one.
+func (cn *Connector) RepApply(e *RepEntry) error {
+	var rs skv.Result
+	if e.Deleted() {
+		rs = cn.RepDel(e.Key)
+	} else {
+		rs = cn.RepPut(e)
+	}
+	if !rs.OK() && !rs.NotFound() {
+		return errors.New(rs.ErrorString())
+	}
+	return nil
+}
+
+type RepConsumerOptions struct {
+
+	// Log version to start after, 0 for the start of the log
+	Offset uint64
+
+	// Number of entries per read, default to 1000
+	Limit int
+
+	// Wait between reads once the end of the log is reached, default to 1
+	// second
+	Interval time.Duration
+}
+
+// RepConsumer tails the write log of a server.
+type RepConsumer struct {
+	conn   *Connector
+	opts   RepConsumerOptions
+	offset uint64
+}
+
+func (cn *Connector) RepConsumer(opts RepConsumerOptions) *RepConsumer {
+
+	if opts.Limit < 1 {
+		opts.Limit = 1000
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+
+	return &RepConsumer{
+		conn:   cn,
+		opts:   opts,
+		offset: opts.Offset,
+	}
+}
+
+// Offset returns the version of the last entry handled, to resume from.
+func (rc *RepConsumer) Offset() uint64 {
+	return atomic.LoadUint64(&rc.offset)
+}
+
+// Run calls fn with every log entry until ctx is done or fn fails. Read
+// errors are retried with backoff, an entry is passed on again only if fn
+// failed on it. A log entry without a version can not move the offset and
+// stops Run with an error. For example, to keep a replica in sync:
+//
+//	rc := src.RepConsumer(lynkstor.RepConsumerOptions{Offset: last})
+//	err := rc.Run(ctx, dst.RepApply)
+func (rc *RepConsumer) Run(ctx context.Context, fn func(e *RepEntry) error) error {
+
+	for try := 0; ; {
+
+		ls, err := rc.conn.RepLog(rc.Offset(), rc.opts.Limit)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		wait := rc.opts.Interval
+
+		if err != nil {
+			if try++; try > 10 {
+				try = 10
+			}
+			wait = time.Duration(try) * time.Second
+			hlog.Printf("warn", "lynkdb/lynkstorgo replication log %s://%s, offset %d: %s",
+				rc.conn.copts.net, rc.conn.copts.addr, rc.Offset(), err)
+		} else {
+			try = 0
+			for _, e := range ls {
+				if e.Meta == nil || e.Meta.Version <= rc.Offset() {
+					return fmt.Errorf("replication log entry %q after offset %d has no newer version",
+						e.Key, rc.Offset())
+				}
+				if err := fn(e); err != nil {
+					return err
+				}
+				atomic.StoreUint64(&rc.offset, e.Meta.Version)
+			}
+			if len(ls) >= rc.opts.Limit {
+				continue
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
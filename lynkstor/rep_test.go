@@ -0,0 +1,85 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lynkdb/iomix/skv"
+)
+
+func TestRepEntryEncode(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		meta  *skv.KvMeta
+		value []byte
+	}{
+		{"value", &skv.KvMeta{Version: 7, Expired: 1000}, []byte("value")},
+		{"no meta", nil, []byte("value")},
+		{"entry like value", &skv.KvMeta{Version: 1}, []byte{kvobj_t_v1, 3, 'a'}},
+		{"empty value", &skv.KvMeta{Version: 2}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			enc, err := RepEntryEncode(tt.meta, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			meta := tt.meta
+			if meta == nil {
+				meta = &skv.KvMeta{}
+			}
+			want, _ := proto.Marshal(meta)
+
+			m, v, ok := entry_split(enc)
+			if !ok || !bytes.Equal(m, want) || !bytes.Equal(v, tt.value) {
+				t.Fatalf("split %v, meta %q, value %q", ok, m, v)
+			}
+
+			e := rep_entry([]byte("key"), enc)
+			if !bytes.Equal(e.Value, tt.value) || e.Deleted() != (len(tt.value) == 0) {
+				t.Fatalf("entry value %q", e.Value)
+			}
+		})
+	}
+}
+
+func TestRepEntryDecode(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain", []byte("value")},
+		{"empty", nil},
+		{"header only", []byte{kvobj_t_v1}},
+		{"short meta", []byte{kvobj_t_v1, 9, 'a', 'b'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, value := RepEntryDecode(tt.data)
+			if meta != nil || !bytes.Equal(value, tt.data) {
+				t.Fatalf("meta %v, value %q", meta, value)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package lynkstor
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/lessos/lessgo/types"
 	"github.com/lynkdb/iomix/skv"
@@ -33,6 +34,9 @@ type Result struct {
 	data   []byte
 	cap    int
 	items  []*Result
+	plain  []byte
+	crypt  *CryptKeyring
+	aad    []byte
 }
 
 func newResult(status uint8, err error) *Result {
@@ -51,6 +55,37 @@ func newResult(status uint8, err error) *Result {
 	return rs
 }
 
+// newResultList wraps per-key replies into one list result, keeping the
+// order of items and giving every item its own OK/NotFound status. If the
+// connection failed the list is incomplete and its error is returned.
+func newResultList(items []*Result) *Result {
+
+	for _, v := range items {
+		if v.status == skv.ResultNetError || v.status == skv.ResultTimeout {
+			return v
+		}
+	}
+
+	rs := &Result{
+		status: skv.ResultOK,
+		cap:    len(items),
+		items:  items,
+	}
+
+	for _, v := range items {
+		if v.status != 0 {
+			continue
+		}
+		if v.cap == 0 || len(v.data) == 0 {
+			v.status = skv.ResultNotFound
+		} else {
+			v.status = skv.ResultOK
+		}
+	}
+
+	return rs
+}
+
 func (rs *Result) Status() uint8 {
 	return rs.status
 }
@@ -67,14 +102,73 @@ func (rs *Result) NotFound() bool {
 	return rs.status == skv.ResultNotFound
 }
 
+// Bytes returns the value, decrypted and decompressed. A value that can not
+// be decrypted or decompressed is returned as nil, Decode reports its error.
 func (rs *Result) Bytes() []byte {
-	if len(rs.data) > 1 {
-		if rs.data[0] == kvobj_t_v1 {
-			offset := int(rs.data[1]) + 2
-			if offset < len(rs.data) {
-				return rs.data[offset:]
-			}
+	bs, _ := rs.plain_value()
+	return bs
+}
+
+func (rs *Result) plain_value() ([]byte, error) {
+	if rs.plain != nil {
+		return rs.plain, nil
+	}
+	bs := rs.value()
+	if rs.crypt != nil && len(bs) > 2 && bs[0] == value_ns_crypt {
+		v, err := rs.crypt.open(bs, rs.aad)
+		if err != nil {
+			return nil, err
 		}
+		rs.plain, bs = v, v
+	}
+	if len(bs) > 2 && bs[0] == value_ns_compress {
+		v, err := value_decompress(bs)
+		if err != nil {
+			return nil, err
+		}
+		rs.plain, bs = v, v
+	}
+	return bs, nil
+}
+
+// crypt_open sets the additional data of the value and decrypts it. On
+// failure the result becomes an error and false is returned.
+func (rs *Result) crypt_open(aad []byte) bool {
+	rs.aad, rs.plain = aad, nil
+	if _, err := rs.plain_value(); err != nil {
+		rs.status, rs.data, rs.items = skv.ResultError, []byte(err.Error()), nil
+		return false
+	}
+	return true
+}
+
+// entry returns a stored entry with its value as returned by Bytes, the
+// meta of the entry is kept.
+func (rs *Result) entry() []byte {
+	meta, value, ok := entry_split(rs.data)
+	if !ok {
+		return rs.Bytes()
+	}
+	if len(value) < 3 || (value[0] != value_ns_crypt && value[0] != value_ns_compress) {
+		return rs.data
+	}
+	plain := rs.Bytes()
+	enc := make([]byte, 0, 2+len(meta)+len(plain))
+	enc = append(enc, kvobj_t_v1, uint8(len(meta)))
+	enc = append(enc, meta...)
+	return append(enc, plain...)
+}
+
+func (rs *Result) kv_entry(i int) *skv.ResultEntry {
+	return &skv.ResultEntry{
+		Key:   rs.items[i].data,
+		Value: rs.items[i+1].entry(),
+	}
+}
+
+func (rs *Result) value() []byte {
+	if _, value, ok := entry_split(rs.data); ok && len(value) > 0 {
+		return value
 	}
 	return rs.data
 }
@@ -157,10 +251,7 @@ func (rs *Result) KvLen() int {
 
 func (rs *Result) KvEach(fn func(entry *skv.ResultEntry) int) int {
 	for i := 1; i < len(rs.items); i += 2 {
-		if fn(&skv.ResultEntry{
-			Key:   rs.items[i-1].data,
-			Value: rs.items[i].data,
-		}) != 0 {
+		if fn(rs.kv_entry(i-1)) != 0 {
 			return (i + 1) / 2
 		}
 	}
@@ -174,10 +265,7 @@ func (rs *Result) KvEntry(i int) *skv.ResultEntry {
 		i = i * 2
 	}
 	if i+1 < len(rs.items) {
-		return &skv.ResultEntry{
-			Key:   rs.items[i].data,
-			Value: rs.items[i+1].data,
-		}
+		return rs.kv_entry(i)
 	}
 	return nil
 }
@@ -185,9 +273,23 @@ func (rs *Result) KvEntry(i int) *skv.ResultEntry {
 func (rs *Result) KvList() []*skv.ResultEntry {
 	ls := []*skv.ResultEntry{}
 	for i := 1; i < len(rs.items); i += 2 {
+		ls = append(ls, rs.kv_entry(i-1))
+	}
+	return ls
+}
+
+// KvListRaw returns the pairs of a list result with the values as stored,
+// without decryption or decompression, for tools that copy data as is.
+func KvListRaw(rs skv.Result) []*skv.ResultEntry {
+	v, ok := rs.(*Result)
+	if !ok {
+		return rs.KvList()
+	}
+	ls := []*skv.ResultEntry{}
+	for i := 1; i < len(v.items); i += 2 {
 		ls = append(ls, &skv.ResultEntry{
-			Key:   rs.items[i-1].data,
-			Value: rs.items[i].data,
+			Key:   v.items[i-1].data,
+			Value: v.items[i].data,
 		})
 	}
 	return ls
@@ -201,8 +303,10 @@ func (rs *Result) KvPairs() []skv.Result {
 	ls := []skv.Result{}
 	for i := 1; i < len(rs.items); i += 2 {
 		ls = append(ls, &Result{
-			key:  rs.items[i-1].data,
-			data: rs.items[i].data,
+			key:   rs.items[i-1].data,
+			data:  rs.items[i].data,
+			crypt: rs.crypt,
+			aad:   rs.items[i].aad,
 		})
 	}
 	return ls
@@ -213,7 +317,11 @@ func (rs *Result) KvKey() []byte {
 }
 
 func (rs *Result) Decode(obj interface{}) error {
-	return skv.ValueDecode(rs.Bytes(), obj)
+	bs, err := rs.plain_value()
+	if err != nil {
+		return err
+	}
+	return value_decode(bs, obj)
 	// bs := rs.Bytes()
 	// if len(bs) < 3 {
 	// 	return errors.New("json: invalid format")
@@ -222,13 +330,15 @@ func (rs *Result) Decode(obj interface{}) error {
 }
 
 func (rs *Result) Meta() *skv.KvMeta {
-	if len(rs.data) > 1 {
-		if rs.data[0] == kvobj_t_v1 {
-			offset := int(rs.data[1]) + 2
-			if offset <= len(rs.data) {
-				return skv.KvMetaDecode(rs.data[2:offset])
-			}
-		}
+	if meta, _, ok := entry_split(rs.data); ok {
+		return skv.KvMetaDecode(meta)
 	}
 	return nil
 }
+
+// cmd_unknown reports whether an error reply says the server does not have
+// the command.
+func cmd_unknown(rs *Result) bool {
+	return rs.status == skv.ResultError &&
+		strings.Contains(strings.ToLower(string(rs.data)), "unknown command")
+}
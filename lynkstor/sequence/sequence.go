@@ -0,0 +1,173 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sequence allocates monotonic ids in blocks.
+//
+// Every named sequence is a counter on the server. One KvIncr (or
+// KvProgIncr) by the block size reserves a whole block of ids, which are
+// then handed out from memory. Ids are unique and increasing per process,
+// but not gapless: the unused rest of a block is lost on restart.
+package sequence
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+type Options struct {
+
+	// Key prefix of the counters, default to "sequence"
+	Prefix string
+
+	// Number of ids reserved per round trip, default to 1000
+	BlockSize int64
+
+	// Store the counters as prog keys [prefix, name] instead of raw keys
+	// prefix:name
+	Prog bool
+}
+
+type Allocator struct {
+	conn skv.Connector
+	opts Options
+	mu   sync.Mutex
+	seqs map[string]*sequence
+}
+
+type sequence struct {
+	mu      sync.Mutex
+	cur     uint64
+	max     uint64
+	spare   *block
+	filling chan struct{}
+	err     error
+}
+
+type block struct {
+	start, end uint64
+}
+
+func New(conn skv.Connector, opts Options) *Allocator {
+	if opts.Prefix == "" {
+		opts.Prefix = "sequence"
+	}
+	if opts.BlockSize < 1 {
+		opts.BlockSize = 1000
+	}
+	return &Allocator{
+		conn: conn,
+		opts: opts,
+		seqs: map[string]*sequence{},
+	}
+}
+
+// Next returns the next id of the named sequence. The next block is
+// reserved in the background once a fifth of the current one is left.
+func (a *Allocator) Next(name string) (uint64, error) {
+
+	if name == "" {
+		return 0, errors.New("invalid name")
+	}
+
+	a.mu.Lock()
+	s, ok := a.seqs[name]
+	if !ok {
+		s = &sequence{}
+		a.seqs[name] = s
+	}
+	a.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// refill in the background once a fifth of the block is left, small
+	// blocks once they are used up
+	low := uint64(a.opts.BlockSize / 5)
+	if low < 1 {
+		low = 1
+	}
+
+	for {
+
+		if s.cur < s.max {
+			s.cur++
+			if s.max-s.cur < low && s.spare == nil && s.filling == nil {
+				a.refill(name, s)
+			}
+			return s.cur, nil
+		}
+
+		if s.spare != nil {
+			s.cur, s.max = s.spare.start-1, s.spare.end
+			s.spare = nil
+			continue
+		}
+
+		if s.filling == nil {
+			a.refill(name, s)
+		}
+
+		ch := s.filling
+		s.mu.Unlock()
+		<-ch
+		s.mu.Lock()
+
+		if s.spare == nil && s.err != nil {
+			err := s.err
+			s.err = nil
+			return 0, err
+		}
+	}
+}
+
+// refill reserves the next block in the background, s.mu must be held.
+func (a *Allocator) refill(name string, s *sequence) {
+
+	s.filling = make(chan struct{})
+
+	go func() {
+		b, err := a.reserve(name)
+
+		s.mu.Lock()
+		s.spare, s.err = b, err
+		close(s.filling)
+		s.filling = nil
+		s.mu.Unlock()
+	}()
+}
+
+func (a *Allocator) reserve(name string) (*block, error) {
+
+	var rs skv.Result
+	if a.opts.Prog {
+		rs = a.conn.KvProgIncr(skv.NewKvProgKey(a.opts.Prefix, name), a.opts.BlockSize)
+	} else {
+		rs = a.conn.KvIncr([]byte(a.opts.Prefix+":"+name), a.opts.BlockSize)
+	}
+	if !rs.OK() {
+		return nil, errors.New(rs.ErrorString())
+	}
+
+	end := rs.Uint64()
+	if end < uint64(a.opts.BlockSize) {
+		return nil, errors.New("invalid counter value")
+	}
+
+	return &block{
+		start: end - uint64(a.opts.BlockSize) + 1,
+		end:   end,
+	}, nil
+}
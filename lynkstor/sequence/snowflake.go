@@ -0,0 +1,116 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequence
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor/lock"
+)
+
+const (
+	snowflake_node_bits = 10
+	snowflake_seq_bits  = 12
+	snowflake_node_max  = 1<<snowflake_node_bits - 1
+	snowflake_seq_max   = 1<<snowflake_seq_bits - 1
+)
+
+var (
+	// 2018-01-01 00:00:00 UTC
+	SnowflakeEpoch int64 = 1514764800000
+
+	ErrNodeLost = errors.New("snowflake node lease lost")
+)
+
+// Snowflake generates 63 bit ids of 41 bits milliseconds since
+// SnowflakeEpoch, 10 bits node number and 12 bits sequence. The node number
+// is leased from the server, so no two live generators share one.
+type Snowflake struct {
+	mu      sync.Mutex
+	node    int64
+	last_ms int64
+	seq     int64
+	lease   *lock.Lock
+}
+
+// NewSnowflake leases a free node number of the generator group name.
+func NewSnowflake(conn skv.Connector, name string, ttl time.Duration) (*Snowflake, error) {
+
+	locker := lock.New(conn, "_snowflake")
+
+	for n := int64(0); n <= snowflake_node_max; n++ {
+		lk, err := locker.TryAcquire(name+":"+strconv.FormatInt(n, 10), ttl)
+		if err == lock.ErrLocked {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &Snowflake{
+			node:  n,
+			lease: lk,
+		}, nil
+	}
+
+	return nil, errors.New("no free snowflake node")
+}
+
+func (s *Snowflake) Node() int64 {
+	return s.node
+}
+
+func (s *Snowflake) Next() (int64, error) {
+
+	select {
+	case <-s.lease.Lost():
+		return 0, ErrNodeLost
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := time.Now().UnixNano() / 1e6
+	if ms < s.last_ms {
+		// the clock went backwards, wait for it to catch up
+		time.Sleep(time.Duration(s.last_ms-ms) * time.Millisecond)
+		ms = s.last_ms
+	}
+
+	if ms == s.last_ms {
+		if s.seq++; s.seq > snowflake_seq_max {
+			for ms <= s.last_ms {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Now().UnixNano() / 1e6
+			}
+			s.seq = 0
+		}
+	} else {
+		s.seq = 0
+	}
+	s.last_ms = ms
+
+	return (ms-SnowflakeEpoch)<<(snowflake_node_bits+snowflake_seq_bits) |
+		s.node<<snowflake_seq_bits | s.seq, nil
+}
+
+// Close releases the node number.
+func (s *Snowflake) Close() error {
+	return s.lease.Release()
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// GorillaStore keeps the session values on the server, the cookie only
+// carries the signed session id.
+type GorillaStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	store   *Store
+}
+
+// NewGorillaStore takes the same authentication and encryption key pairs as
+// sessions.NewCookieStore.
+func NewGorillaStore(conn Connector, opts Options, keyPairs ...[]byte) *GorillaStore {
+	s := NewStore(conn, opts)
+	return &GorillaStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   int(s.opts.Ttl / time.Second),
+			HttpOnly: true,
+		},
+		store: s,
+	}
+}
+
+func (s *GorillaStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *GorillaStore) New(r *http.Request, name string) (*sessions.Session, error) {
+
+	opts := *s.Options
+
+	sess := sessions.NewSession(s, name)
+	sess.Options = &opts
+	sess.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return sess, nil
+	}
+
+	if err = securecookie.DecodeMulti(name, c.Value, &sess.ID, s.Codecs...); err != nil {
+		return sess, err
+	}
+
+	data, found, err := s.store.Find(sess.ID)
+	if err != nil {
+		return sess, err
+	} else if !found {
+		sess.ID = ""
+		return sess, nil
+	}
+
+	if err = (securecookie.GobEncoder{}).Deserialize(data, &sess.Values); err != nil {
+		return sess, err
+	}
+	sess.IsNew = false
+
+	return sess, nil
+}
+
+// Save writes the session, a negative MaxAge deletes it.
+func (s *GorillaStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+
+	if sess.Options.MaxAge < 0 {
+		if sess.ID != "" {
+			if err := s.store.Delete(sess.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(sess.Name(), "", sess.Options))
+		return nil
+	}
+
+	if sess.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return err
+		}
+		sess.ID = id
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(sess.Values)
+	if err != nil {
+		return err
+	}
+
+	var expiry time.Time
+	if sess.Options.MaxAge > 0 {
+		expiry = time.Now().Add(time.Duration(sess.Options.MaxAge) * time.Second)
+	}
+	if err := s.store.Commit(sess.ID, data, expiry); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(sess.Name(), sess.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(sess.Name(), encoded, sess.Options))
+
+	return nil
+}
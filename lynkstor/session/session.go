@@ -0,0 +1,130 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package session stores HTTP sessions as raw keys prefix:id with a TTL.
+//
+// Store implements the scs Store interface, GorillaStore implements the
+// gorilla/sessions Store interface on top of it.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/lynkdb/iomix/skv"
+)
+
+// Connector is the subset of lynkstor.Connector used by the stores.
+type Connector interface {
+	KvPut(key []byte, value interface{}, opts *skv.KvWriteOptions) skv.Result
+	KvGet(key []byte) skv.Result
+	KvDel(keys ...[]byte) skv.Result
+	KvExpire(key []byte, ttl time.Duration) skv.Result
+}
+
+type Options struct {
+
+	// Key prefix of the sessions, default to "session"
+	Prefix string
+
+	// Lifetime of a session since it was last saved, default to 24 hours
+	Ttl time.Duration
+
+	// Extend the lifetime by Ttl on every read
+	Sliding bool
+}
+
+type Store struct {
+	conn Connector
+	opts Options
+}
+
+func NewStore(conn Connector, opts Options) *Store {
+	if opts.Prefix == "" {
+		opts.Prefix = "session"
+	}
+	if opts.Ttl < time.Second {
+		opts.Ttl = 24 * time.Hour
+	}
+	return &Store{
+		conn: conn,
+		opts: opts,
+	}
+}
+
+// NewID returns a random session id of 256 bits.
+func NewID() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func (s *Store) key(id string) []byte {
+	return []byte(s.opts.Prefix + ":" + id)
+}
+
+// Find returns the data of a session, found is false if the session does
+// not exist or has expired.
+func (s *Store) Find(id string) ([]byte, bool, error) {
+
+	if id == "" {
+		return nil, false, nil
+	}
+
+	rs := s.conn.KvGet(s.key(id))
+	if rs.NotFound() {
+		return nil, false, nil
+	} else if !rs.OK() {
+		return nil, false, errors.New(rs.ErrorString())
+	}
+
+	if s.opts.Sliding {
+		s.conn.KvExpire(s.key(id), s.opts.Ttl)
+	}
+
+	return []byte(rs.Bytex()), true, nil
+}
+
+// Commit saves the data of a session until expiry. A zero expiry uses the
+// Ttl of the store.
+func (s *Store) Commit(id string, data []byte, expiry time.Time) error {
+
+	ttl := s.opts.Ttl
+	if !expiry.IsZero() {
+		if ttl = time.Until(expiry); ttl < time.Millisecond {
+			return s.Delete(id)
+		}
+	}
+
+	rs := s.conn.KvPut(s.key(id), data, &skv.KvWriteOptions{
+		Ttl: int64(ttl / time.Millisecond),
+	})
+	if !rs.OK() {
+		return errors.New(rs.ErrorString())
+	}
+
+	return nil
+}
+
+// Delete removes a session, for example on logout.
+func (s *Store) Delete(id string) error {
+	if rs := s.conn.KvDel(s.key(id)); !rs.OK() && !rs.NotFound() {
+		return errors.New(rs.ErrorString())
+	}
+	return nil
+}
@@ -40,6 +40,14 @@ func (cn *Connector) FoMpPut(sets skv.FileObjectEntryBlock) skv.Result {
 	if !sets.Valid() {
 		return newResult(skv.ResultBadArgument, nil)
 	}
+	if cn.keyring() != nil {
+		data, err := cn.value_encrypt(sets.Data, crypt_aad_fo(sets.Path, sets.Num))
+		if err != nil {
+			return newResult(skv.ResultBadArgument, err)
+		}
+		// the server checks the block sum against the stored bytes
+		sets.Data, sets.Sum = data, uint64(crc32.ChecksumIEEE(data))
+	}
 	bs, err := proto.Marshal(&sets)
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
@@ -67,12 +75,56 @@ func (cn *Connector) FoRevScan(offset, cutset string, limit int) skv.Result {
 	return cn.Cmd("forevscan", skv.FileObjectPathEncode(offset), skv.FileObjectPathEncode(cutset), limit)
 }
 
+func (cn *Connector) FoDel(path_key string) skv.Result {
+	rs := cn.Cmd("fodel", skv.FileObjectPathEncode(path_key))
+	if rs.OK() {
+		cn.KvProgDel(fo_sums_key(path_key), nil)
+	}
+	return rs
+}
+
+// FoSumsNs is the first item of the prog keys [FoSumsNs, path] that hold
+// the block sums FoFilePut records for an object, so that FoBlockSums does
+// not need to read the blocks. These records are internal, the tools that
+// copy or compare prog keys skip them.
+const FoSumsNs = "_fo_sums"
+
+// IsFoSumsKey reports whether k is the key of a block sums record.
+func IsFoSumsKey(k *skv.KvProgKey) bool {
+	return k != nil && len(k.Items) > 0 && string(k.Items[0].Data) == FoSumsNs
+}
+
+type foSums struct {
+	Sn   uint32   `json:"sn"`
+	Size uint64   `json:"size"`
+	Sums []uint32 `json:"sums"`
+}
+
+func fo_sums_key(path string) skv.KvProgKey {
+	return skv.NewKvProgKey(FoSumsNs, path)
+}
+
+func (cn *Connector) fo_sums_put(path string, sums *foSums) {
+	if ent, err := NewCodecEntry(nil, sums); err == nil {
+		cn.KvProgPut(fo_sums_key(path), ent, nil)
+	}
+}
+
 func (cn *Connector) FoFilePut(src_path, dst_path string) skv.Result {
+	return cn.FoFilePutProgress(src_path, dst_path, nil)
+}
+
+// FoFilePutProgress uploads a file like FoFilePut and calls progress with
+// the number of bytes sent after every block. An upload resumes onto an
+// existing object of the same size, which is kept as is once complete, so
+// an object is replaced by deleting it first.
+func (cn *Connector) FoFilePutProgress(src_path, dst_path string, progress func(done, total int64)) skv.Result {
 
 	fp, err := os.Open(src_path)
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
+	defer fp.Close()
 
 	st, err := fp.Stat()
 	if err != nil {
@@ -112,6 +164,7 @@ func (cn *Connector) FoFilePut(src_path, dst_path string) skv.Result {
 	}
 
 	block_dones := types.ArrayUint32(fo_meta.Blocks)
+	sums := []uint32{}
 
 	num := uint32(fo_meta.Size / block_size)
 	if num > 0 && (fo_meta.Size%block_size) == 0 {
@@ -120,32 +173,113 @@ func (cn *Connector) FoFilePut(src_path, dst_path string) skv.Result {
 	// fmt.Println("block num ", num)
 
 	for n := uint32(0); n <= num; n++ {
-		if block_dones.Has(n) {
-			continue
-		}
 
 		bsize := int(block_size)
-		if n == num {
+		if n == num && fo_meta.Size%block_size > 0 {
 			bsize = int(fo_meta.Size % block_size)
 		}
 
+		// blocks of a resumed upload are read too, the sums of all blocks
+		// are recorded once the object is complete
 		bs := make([]byte, bsize)
-		if rn, err := fp.ReadAt(bs, int64(n)*int64(block_size)); err != nil {
+		if rn, err := fp.ReadAt(bs, int64(n)*int64(block_size)); err != nil && err != io.EOF {
 			return newResult(skv.ResultBadArgument, err)
 		} else if rn != bsize {
 			return newResult(skv.ResultBadArgument, errors.New("io error"))
-		} else {
+		}
+		sum := crc32.ChecksumIEEE(bs)
+		sums = append(sums, sum)
+
+		if !block_dones.Has(n) {
 			mp_block := skv.NewFileObjectEntryBlock(dst_path, fo_meta.Size, n, bs, fo_meta.CommitKey)
-			mp_block.Sum = uint64(crc32.ChecksumIEEE(bs))
+			mp_block.Sum = uint64(sum)
 			if rs = cn.FoMpPut(mp_block); !rs.OK() {
 				return rs
 			}
 		}
+
+		if progress != nil {
+			progress(fo_block_end(n, block_size, fo_meta.Size), st.Size())
+		}
+	}
+
+	if rs := cn.FoGet(dst_path); rs.OK() {
+		var done skv.FileObjectEntryMeta
+		if err := rs.Decode(&done); err == nil && done.Size == fo_meta.Size {
+			cn.fo_sums_put(dst_path, &foSums{
+				Sn:   done.Sn,
+				Size: done.Size,
+				Sums: sums,
+			})
+		}
 	}
 
 	return newResult(skv.ResultOK, nil)
 }
 
+func fo_block_end(n uint32, block_size, size uint64) int64 {
+	if end := (uint64(n) + 1) * block_size; end < size {
+		return int64(end)
+	}
+	return int64(size)
+}
+
+// FoBlockSums returns the size of a file object and the CRC32 of the plain
+// data of each of its blocks. The sums recorded by FoFilePut are used while
+// they match the size and sn of the object, the blocks are only read for
+// objects written otherwise. It writes nothing, and fails on encrypted
+// blocks without the keyring rather than returning sums of ciphertext.
+func (cn *Connector) FoBlockSums(path string) (uint64, []uint32, error) {
+
+	rs := cn.FoGet(path)
+	if !rs.OK() {
+		return 0, nil, errors.New(rs.String())
+	}
+
+	var fo_meta skv.FileObjectEntryMeta
+	if err := rs.Decode(&fo_meta); err != nil {
+		return 0, nil, err
+	}
+
+	if !fo_meta.AttrAllow(skv.FileObjectEntryAttrBlockSize4) {
+		return 0, nil, errors.New("protocol error")
+	}
+
+	var rec foSums
+	if rs := cn.KvProgGet(fo_sums_key(path)); rs.OK() && rs.Decode(&rec) == nil &&
+		rec.Sn == fo_meta.Sn && rec.Size == fo_meta.Size {
+		return rec.Size, rec.Sums, nil
+	}
+
+	sums := []uint32{}
+	for n := uint32(0); uint64(n)*skv.FileObjectBlockSize4 < fo_meta.Size; n++ {
+
+		blk := skv.NewFileObjectEntryBlock(path, 0, n, nil, "")
+		blk.Sn = fo_meta.Sn
+
+		rs := cn.FoMpGet(blk)
+		if !rs.OK() {
+			return 0, nil, errors.New("io error")
+		}
+
+		var fo_block skv.FileObjectEntryBlock
+		if err := rs.Decode(&fo_block); err != nil {
+			return 0, nil, err
+		}
+
+		if cn.keyring() == nil && IsEncrypted(fo_block.Data) {
+			return 0, nil, errors.New("encrypted block, keyring required")
+		}
+		data, err := cn.value_decrypt(fo_block.Data, crypt_aad_fo(blk.Path, n))
+		if err != nil {
+			return 0, nil, err
+		}
+		sums = append(sums, crc32.ChecksumIEEE(data))
+	}
+
+	return fo_meta.Size, sums, nil
+}
+
 type FoReadSeeker struct {
 	conn      *Connector
 	db_meta   *skv.KvMeta
@@ -241,6 +375,11 @@ func (fo *FoReadSeeker) Read(b []byte) (n int, err error) {
 			if len(fo_block.Data) < 1 {
 				return 0, errors.New("io error")
 			}
+			data, err := fo.conn.value_decrypt(fo_block.Data, crypt_aad_fo(blk_block.Path, blk_num))
+			if err != nil {
+				return 0, err
+			}
+			fo_block.Data = data
 
 			fo.cur_block = &fo_block
 
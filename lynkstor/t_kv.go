@@ -15,35 +15,114 @@
 package lynkstor
 
 import (
+	"errors"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/lynkdb/iomix/skv"
 )
 
 func (c *Connector) KvNew(key []byte, value interface{}, opts *skv.KvWriteOptions) skv.Result {
+	bs, err := value_encode(c.Codec(), value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	if bs, err = c.value_encrypt(c.value_compress(bs), crypt_aad_kv(key)); err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
 	args := []interface{}{
-		key, skv.ValueEncodeBytes(value, nil), "NX",
+		key, bs, "NX",
 	}
 	if opts != nil && opts.Ttl > 0 {
 		args = append(args, "PX")
 		args = append(args, strconv.FormatInt(opts.Ttl, 10))
 	}
+	defer c.cache_kv_del(key)
 	return c.Cmd("kvput", args...)
 }
 
 func (c *Connector) KvPut(key []byte, value interface{}, opts *skv.KvWriteOptions) skv.Result {
+	cmd, err := c.kv_put_cmd(key, value, opts)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	defer c.cache_kv_del(key)
+	return c.Cmd(cmd.cmd, cmd.args...)
+}
+
+// kv_put_cmd encodes, compresses and encrypts a value into its kvput
+// command.
+func (c *Connector) kv_put_cmd(key []byte, value interface{}, opts *skv.KvWriteOptions) (*cmdEntry, error) {
+	bs, err := value_encode(c.Codec(), value)
+	if err != nil {
+		return nil, err
+	}
+	if bs, err = c.value_encrypt(c.value_compress(bs), crypt_aad_kv(key)); err != nil {
+		return nil, err
+	}
 	args := []interface{}{
-		key, skv.ValueEncodeBytes(value, nil),
+		key, bs,
 	}
 	if opts != nil && opts.Ttl > 0 {
 		args = append(args, "PX")
 		args = append(args, strconv.FormatInt(opts.Ttl, 10))
 	}
-	return c.Cmd("kvput", args...)
+	return &cmdEntry{"kvput", args}, nil
 }
 
 func (c *Connector) KvGet(key []byte) skv.Result {
-	return c.Cmd("kvget", key)
+	if ca := c.read_cache(); ca != nil {
+		return ca.get("k"+string(key), func() skv.Result {
+			return c.result_open(c.Cmd("kvget", key), crypt_aad_kv(key))
+		})
+	}
+	return c.result_open(c.Cmd("kvget", key), crypt_aad_kv(key))
+}
+
+// KvMGet returns one result per key, in the order of keys. Missing keys
+// are reported as NotFound items of the list.
+func (c *Connector) KvMGet(keys ...[]byte) skv.Result {
+
+	if len(keys) == 0 {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+
+	if atomic.LoadInt32(&c.mget_off) == 0 {
+		args := []interface{}{}
+		for _, v := range keys {
+			args = append(args, v)
+		}
+		if rs, ok := c.Cmd("kvmget", args...).(*Result); ok {
+			if rs.status != skv.ResultError && len(rs.items) == len(keys) {
+				return c.result_mget_open(newResultList(rs.items), keys)
+			}
+			if cmd_unknown(rs) {
+				// the server has no native multi-get, use pipelined gets
+				atomic.StoreInt32(&c.mget_off, 1)
+			} else if rs.status != skv.ResultOK && rs.status != skv.ResultNotFound {
+				return rs
+			}
+		}
+	}
+
+	cmds := []*cmdEntry{}
+	for _, v := range keys {
+		cmds = append(cmds, &cmdEntry{"kvget", []interface{}{v}})
+	}
+
+	return c.result_mget_open(newResultList(c.pipe(cmds)), keys)
+}
+
+func (c *Connector) result_mget_open(rs *Result, keys [][]byte) *Result {
+	if c.keyring() != nil && len(rs.items) == len(keys) {
+		for i, v := range rs.items {
+			if v.status == skv.ResultOK {
+				v.crypt_open(crypt_aad_kv(keys[i]))
+			}
+		}
+	}
+	return rs
 }
 
 func (c *Connector) KvDel(keys ...[]byte) skv.Result {
@@ -51,18 +130,20 @@ func (c *Connector) KvDel(keys ...[]byte) skv.Result {
 	for _, v := range keys {
 		args = append(args, v)
 	}
+	defer c.cache_kv_del(keys...)
 	return c.Cmd("kvdel", args...)
 }
 
 func (c *Connector) KvScan(offset, cutset []byte, limit int) skv.Result {
-	return c.Cmd("kvscan", offset, cutset, limit)
+	return c.result_list_open(c.Cmd("kvscan", offset, cutset, limit), crypt_aad_kv)
 }
 
 func (c *Connector) KvRevScan(offset, cutset []byte, limit int) skv.Result {
-	return c.Cmd("kvrevscan", offset, cutset, limit)
+	return c.result_list_open(c.Cmd("kvrevscan", offset, cutset, limit), crypt_aad_kv)
 }
 
 func (c *Connector) KvIncr(key []byte, increment int64) skv.Result {
+	defer c.cache_kv_del(key)
 	return c.Cmd("kvincr", key, increment)
 }
 
@@ -70,6 +151,53 @@ func (c *Connector) KvMeta(key []byte) skv.Result {
 	return c.Cmd("kvmeta", key)
 }
 
+// KvExpire sets the time to live of an existing key without rewriting its
+// value.
+func (c *Connector) KvExpire(key []byte, ttl time.Duration) skv.Result {
+	if ttl < time.Millisecond {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+	defer c.cache_kv_del(key)
+	return c.Cmd("kvexpire", key, int64(ttl/time.Millisecond))
+}
+
+// KvPersist removes the time to live of a key.
+func (c *Connector) KvPersist(key []byte) skv.Result {
+	defer c.cache_kv_del(key)
+	return c.Cmd("kvpersist", key)
+}
+
+// KvTTL returns the remaining time to live of a key, or a negative duration
+// if the key never expires.
+func (c *Connector) KvTTL(key []byte) (time.Duration, error) {
+	return meta_ttl(c.KvMeta(key))
+}
+
+func meta_ttl(rs skv.Result) (time.Duration, error) {
+
+	if rs.NotFound() {
+		return 0, ErrNotFound
+	} else if !rs.OK() {
+		return 0, errors.New(rs.ErrorString())
+	}
+
+	meta := rs.Meta()
+	if meta == nil {
+		return 0, errors.New("no meta found")
+	}
+
+	if meta.Expired == 0 {
+		return -1, nil
+	}
+
+	ttl := int64(meta.Expired) - (time.Now().UnixNano() / 1e6)
+	if ttl < 1 {
+		return 0, ErrNotFound
+	}
+
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
 // func (c *Connector) KvBatch(batch *skv.KvEngineBatch, opts *skv.KvWriteOptions) error {
 // 	return nil
 // }
@@ -0,0 +1,84 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"testing"
+
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor/lynkstortest"
+)
+
+func TestKvMGet(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		native bool // the server knows kvmget
+		mgets  int  // kvmget commands sent by two calls
+		gets   int  // kvget commands sent by two calls
+	}{
+		{"native", true, 2, 0},
+		{"fallback", false, 1, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			px := lynkstortest.NewProxy()
+			c := test_connector(t, px)
+
+			if !tt.native {
+				px.Add(lynkstortest.Rule{
+					Cmd:     "kvmget",
+					Fault:   lynkstortest.FaultError,
+					Message: "unknown command 'kvmget'",
+				})
+			}
+
+			for i := 0; i < 2; i++ {
+
+				rs := c.KvMGet([]byte("a"), []byte("missing"), []byte("b"))
+				if !rs.OK() || rs.ListLen() != 3 {
+					t.Fatalf("status %d, %d items", rs.Status(), rs.ListLen())
+				}
+
+				ls := rs.List()
+				for j, want := range []string{"v:a", "", "v:b"} {
+					if want == "" {
+						if !ls[j].NotFound() {
+							t.Fatalf("item #%d: status %d, want not found", j, ls[j].Status())
+						}
+					} else if !ls[j].OK() || string(ls[j].Bytes()) != want {
+						t.Fatalf("item #%d: status %d, %q", j, ls[j].Status(), ls[j].Bytes())
+					}
+				}
+			}
+
+			if n := px.Count("kvmget"); n != tt.mgets {
+				t.Fatalf("sent kvmget %d times, want %d", n, tt.mgets)
+			}
+			if n := px.Count("kvget"); n != tt.gets {
+				t.Fatalf("sent kvget %d times, want %d", n, tt.gets)
+			}
+		})
+	}
+
+	px := lynkstortest.NewProxy()
+	c := test_connector(t, px)
+	if rs := c.KvMGet(); rs.Status() != skv.ResultBadArgument {
+		t.Fatalf("status %d for no keys, want bad argument", rs.Status())
+	}
+}
@@ -15,6 +15,9 @@
 package lynkstor
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/lynkdb/iomix/skv"
 )
@@ -34,15 +37,23 @@ func (cn *Connector) KvProgPut(key skv.KvProgKey, val skv.KvEntry, opts *skv.KvP
 	if !key.Valid() || !val.Valid() {
 		return newResult(skv.ResultBadArgument, nil)
 	}
+	if cn.keyring() != nil && opts != nil && opts.PrevSum != 0 {
+		return newResult(skv.ResultBadArgument, ErrCryptPrevSum)
+	}
+	value, err := cn.value_encrypt(cn.value_compress(val.Value), crypt_aad_prog(&key))
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
 	pc := &skv.KvProgKeyValueCommit{
 		Key:     &key,
-		Value:   val.Value,
+		Value:   value,
 		Options: opts,
 	}
 	bs, err := proto.Marshal(pc)
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
+	defer cn.cache_prog_del(key)
 	return cn.Cmd("kvprogput", bs)
 }
 
@@ -54,13 +65,74 @@ func (cn *Connector) KvProgGet(key skv.KvProgKey) skv.Result {
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
-	return cn.Cmd("kvprogget", bs)
+	if ca := cn.read_cache(); ca != nil {
+		return ca.get("p"+string(bs), func() skv.Result {
+			return cn.result_open(cn.Cmd("kvprogget", bs), crypt_aad_prog(&key))
+		})
+	}
+	return cn.result_open(cn.Cmd("kvprogget", bs), crypt_aad_prog(&key))
+}
+
+// KvProgMGet returns one result per key, in the order of keys. Missing keys
+// are reported as NotFound items of the list.
+func (cn *Connector) KvProgMGet(keys ...skv.KvProgKey) skv.Result {
+
+	if len(keys) == 0 {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+
+	args := []interface{}{}
+	for _, key := range keys {
+		if !key.Valid() {
+			return newResult(skv.ResultBadArgument, nil)
+		}
+		bs, err := proto.Marshal(&key)
+		if err != nil {
+			return newResult(skv.ResultBadArgument, err)
+		}
+		args = append(args, bs)
+	}
+
+	if atomic.LoadInt32(&cn.progmget_off) == 0 {
+		if rs, ok := cn.Cmd("kvprogmget", args...).(*Result); ok {
+			if rs.status != skv.ResultError && len(rs.items) == len(keys) {
+				return cn.result_progmget_open(newResultList(rs.items), keys)
+			}
+			if cmd_unknown(rs) {
+				// the server has no native multi-get, use pipelined gets
+				atomic.StoreInt32(&cn.progmget_off, 1)
+			} else if rs.status != skv.ResultOK && rs.status != skv.ResultNotFound {
+				return rs
+			}
+		}
+	}
+
+	cmds := []*cmdEntry{}
+	for _, v := range args {
+		cmds = append(cmds, &cmdEntry{"kvprogget", []interface{}{v}})
+	}
+
+	return cn.result_progmget_open(newResultList(cn.pipe(cmds)), keys)
+}
+
+func (cn *Connector) result_progmget_open(rs *Result, keys []skv.KvProgKey) *Result {
+	if cn.keyring() != nil && len(rs.items) == len(keys) {
+		for i, v := range rs.items {
+			if v.status == skv.ResultOK {
+				v.crypt_open(crypt_aad_prog(&keys[i]))
+			}
+		}
+	}
+	return rs
 }
 
 func (cn *Connector) KvProgDel(key skv.KvProgKey, opts *skv.KvProgWriteOptions) skv.Result {
 	if !key.Valid() {
 		return newResult(skv.ResultBadArgument, nil)
 	}
+	if cn.keyring() != nil && opts != nil && opts.PrevSum != 0 {
+		return newResult(skv.ResultBadArgument, ErrCryptPrevSum)
+	}
 	pc := &skv.KvProgKeyValueCommit{
 		Key:     &key,
 		Options: opts,
@@ -69,6 +141,7 @@ func (cn *Connector) KvProgDel(key skv.KvProgKey, opts *skv.KvProgWriteOptions)
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
+	defer cn.cache_prog_del(key)
 	return cn.Cmd("kvprogdel", bs)
 }
 
@@ -84,7 +157,7 @@ func (cn *Connector) KvProgScan(offset, cutset skv.KvProgKey, limit int) skv.Res
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
-	return cn.Cmd("kvprogscan", k1, k2, limit)
+	return cn.result_list_open(cn.Cmd("kvprogscan", k1, k2, limit), crypt_aad_prog_raw)
 }
 
 func (cn *Connector) KvProgRevScan(offset, cutset skv.KvProgKey, limit int) skv.Result {
@@ -99,7 +172,7 @@ func (cn *Connector) KvProgRevScan(offset, cutset skv.KvProgKey, limit int) skv.
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
-	return cn.Cmd("kvprogrevscan", k1, k2, limit)
+	return cn.result_list_open(cn.Cmd("kvprogrevscan", k1, k2, limit), crypt_aad_prog_raw)
 }
 
 func (cn *Connector) KvProgIncr(key skv.KvProgKey, incr int64) skv.Result {
@@ -110,6 +183,7 @@ func (cn *Connector) KvProgIncr(key skv.KvProgKey, incr int64) skv.Result {
 	if err != nil {
 		return newResult(skv.ResultBadArgument, err)
 	}
+	defer cn.cache_prog_del(key)
 	return cn.Cmd("kvprogincr", bs, incr)
 }
 
@@ -123,3 +197,36 @@ func (cn *Connector) KvProgMeta(key skv.KvProgKey) skv.Result {
 	}
 	return cn.Cmd("kvprogmeta", bs)
 }
+
+// KvProgExpire sets the time to live of an existing key without rewriting
+// its value.
+func (cn *Connector) KvProgExpire(key skv.KvProgKey, ttl time.Duration) skv.Result {
+	if !key.Valid() || ttl < time.Millisecond {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+	bs, err := proto.Marshal(&key)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	defer cn.cache_prog_del(key)
+	return cn.Cmd("kvprogexpire", bs, int64(ttl/time.Millisecond))
+}
+
+// KvProgPersist removes the time to live of a key.
+func (cn *Connector) KvProgPersist(key skv.KvProgKey) skv.Result {
+	if !key.Valid() {
+		return newResult(skv.ResultBadArgument, nil)
+	}
+	bs, err := proto.Marshal(&key)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	defer cn.cache_prog_del(key)
+	return cn.Cmd("kvprogpersist", bs)
+}
+
+// KvProgTTL returns the remaining time to live of a key, or a negative
+// duration if the key never expires.
+func (cn *Connector) KvProgTTL(key skv.KvProgKey) (time.Duration, error) {
+	return meta_ttl(cn.KvProgMeta(key))
+}
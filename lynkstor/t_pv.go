@@ -22,7 +22,11 @@ import (
 )
 
 func (cn *Connector) PvNew(path string, value interface{}, opts *skv.KvProgWriteOptions) skv.Result {
-	return cn.KvProgNew(pv_path_parser(path), skv.NewKvEntry(value), opts)
+	entry, err := cn.kv_entry(value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	return cn.KvProgNew(pv_path_parser(path), entry, opts)
 }
 
 func (cn *Connector) PvDel(path string, opts *skv.KvProgWriteOptions) skv.Result {
@@ -30,7 +34,11 @@ func (cn *Connector) PvDel(path string, opts *skv.KvProgWriteOptions) skv.Result
 }
 
 func (cn *Connector) PvPut(path string, value interface{}, opts *skv.KvProgWriteOptions) skv.Result {
-	return cn.KvProgPut(pv_path_parser(path), skv.NewKvEntry(value), opts)
+	entry, err := cn.kv_entry(value)
+	if err != nil {
+		return newResult(skv.ResultBadArgument, err)
+	}
+	return cn.KvProgPut(pv_path_parser(path), entry, opts)
 }
 
 func (cn *Connector) PvGet(path string) skv.Result {
@@ -0,0 +1,229 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lynkstor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hooto/hlog4g/hlog"
+	"github.com/lynkdb/iomix/skv"
+)
+
+const (
+	WatchEventPut    uint8 = 1
+	WatchEventDelete uint8 = 2
+	WatchEventExpire uint8 = 3
+	WatchEventError  uint8 = 9
+)
+
+type WatchEvent struct {
+	Type    uint8
+	Key     []byte
+	Version uint64
+	Value   skv.Result
+}
+
+type watcher struct {
+	conn    *Connector
+	cmd     string
+	keys    []interface{}
+	aad     func(key []byte) []byte
+	version uint64
+	events  chan *WatchEvent
+}
+
+// Watch streams the changes of all prog keys under prefix. The stream uses
+// a dedicated connection outside the pool, reconnects on network errors and
+// resumes from the last seen version. The channel is closed when ctx is done
+// or after a WatchEventError event.
+func (cn *Connector) Watch(ctx context.Context, prefix skv.KvProgKey) (<-chan *WatchEvent, error) {
+	if !prefix.Valid() {
+		return nil, errors.New("invalid prefix")
+	}
+	bs, err := proto.Marshal(&prefix)
+	if err != nil {
+		return nil, err
+	}
+	return cn.watch(ctx, "kvprogwatch", crypt_aad_prog_raw, bs), nil
+}
+
+// KvWatch streams the changes of raw keys in the range of offset to cutset.
+func (cn *Connector) KvWatch(ctx context.Context, offset, cutset []byte) (<-chan *WatchEvent, error) {
+	if len(offset) == 0 || len(cutset) == 0 {
+		return nil, errors.New("invalid key range")
+	}
+	return cn.watch(ctx, "kvwatch", crypt_aad_kv, offset, cutset), nil
+}
+
+func (cn *Connector) watch(ctx context.Context, cmd string, aad func(key []byte) []byte, keys ...interface{}) <-chan *WatchEvent {
+	w := &watcher{
+		conn:   cn,
+		cmd:    cmd,
+		keys:   keys,
+		aad:    aad,
+		events: make(chan *WatchEvent, 64),
+	}
+	go w.run(ctx)
+	return w.events
+}
+
+func (w *watcher) run(ctx context.Context) {
+
+	defer close(w.events)
+
+	for try := 1; ; try++ {
+
+		fatal, err := w.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if fatal {
+			select {
+			case w.events <- &WatchEvent{
+				Type:  WatchEventError,
+				Value: newResult(skv.ResultError, err),
+			}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		if try > 10 {
+			try = 10
+		}
+
+		select {
+		case <-time.After(time.Duration(try) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+
+		hlog.Printf("info", "lynkdb/lynkstorgo watch reconnect %s://%s, version %d",
+			w.conn.copts.net, w.conn.copts.addr, w.version)
+	}
+}
+
+func (w *watcher) stream(ctx context.Context) (bool, error) {
+
+	cli, err := newClient(w.conn.copts, -1)
+	if err != nil {
+		return err == err_auth, err
+	}
+	defer cli.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func(sock net.Conn) {
+		select {
+		case <-ctx.Done():
+			sock.Close()
+		case <-done:
+		}
+	}(cli.sock)
+
+	args := append(append([]interface{}{}, w.keys...), w.version)
+	buf, err := send_buf_cmd(w.cmd, args)
+	if err != nil {
+		return true, err
+	}
+
+	if rs := cli.send(buf); rs != nil {
+		return false, errors.New(string(rs.data))
+	}
+
+	for {
+
+		cli.sock.SetDeadline(time.Time{})
+
+		rs, err := cli.cmd_parse()
+		if err != nil {
+			return false, err
+		}
+
+		if rs.status == skv.ResultError {
+			return true, errors.New(string(rs.data))
+		}
+
+		ev := watch_event_parse(rs)
+		if ev == nil {
+			continue
+		}
+
+		if v, ok := ev.Value.(*Result); ok && v.OK() {
+			if kr := w.conn.keyring(); kr != nil {
+				v.crypt = kr
+				v.crypt_open(w.aad(ev.Key))
+			}
+		}
+
+		if ev.Version > w.version {
+			w.version = ev.Version
+		}
+
+		select {
+		case w.events <- ev:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// watch_event_parse decodes a pushed reply of [type, key, version, value].
+// Other replies, such as the +OK that confirms the subscription, are skipped.
+func watch_event_parse(rs *Result) *WatchEvent {
+
+	if len(rs.items) < 3 {
+		return nil
+	}
+
+	ev := &WatchEvent{
+		Key: rs.items[1].data,
+	}
+
+	switch string(rs.items[0].data) {
+	case "put":
+		ev.Type = WatchEventPut
+	case "del":
+		ev.Type = WatchEventDelete
+	case "expire":
+		ev.Type = WatchEventExpire
+	default:
+		return nil
+	}
+
+	if v, err := strconv.ParseUint(string(rs.items[2].data), 10, 64); err == nil {
+		ev.Version = v
+	}
+
+	value := &Result{
+		status: skv.ResultNotFound,
+		key:    ev.Key,
+	}
+	if len(rs.items) > 3 && len(rs.items[3].data) > 0 {
+		value.status = skv.ResultOK
+		value.data = rs.items[3].data
+		value.cap = 1
+	}
+	ev.Value = value
+
+	return ev
+}
@@ -12,16 +12,39 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// kvgo_to_lynkstor copies the prog keys of a kvgo data directory into a
+// lynkstor server.
+//
+//	--src_dir      kvgo data directory
+//	--src_zone     zone name to replace in keys and json values, default local
+//	--zone_fields  comma separated json fields that hold a zone name,
+//	               default zone,zone_id,zone_name
+//	--dst_host     --dst_port --dst_auth of the lynkstor server
+//	--dst_zone     zone name written instead of src_zone
+//	--namespaces   comma separated first key items to copy, default all
+//	--dry-run      scan and count only, write nothing
+//	--batch        keys per scan batch, default 1000
+//	--workers      parallel writers, default 4
+//	--checkpoint   file of the last copied key, a later run resumes after it.
+//	               It never moves past a key that failed to copy
+//	--verify       read every copied key back and compare the values
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/hooto/hflag4g/hflag"
 	"github.com/lessos/lessgo/types"
 	"github.com/lynkdb/iomix/connect"
@@ -31,6 +54,28 @@ import (
 	"github.com/lynkdb/lynkstorgo/lynkstor"
 )
 
+const (
+	kvgo_ns_prog uint8 = 36
+
+	value_ns_bytes uint8 = 0
+	value_ns_json  uint8 = 20
+	value_ns_prog  uint8 = 32
+)
+
+type migrator struct {
+	conn        *lynkstor.Connector
+	src_zone    string
+	dst_zone    string
+	zone_fields types.ArrayString
+	nss         types.ArrayString
+	dry_run     bool
+	workers     int
+
+	mu       sync.Mutex
+	num      map[string]int
+	prefixes map[string]int
+}
+
 func main() {
 
 	data_dir, ok := hflag.Value("src_dir")
@@ -54,11 +99,22 @@ func main() {
 		log.Fatal(err)
 	}
 
-	dst_host := "127.0.0.1"
-	dst_port := "6378"
-	dst_auth := ""
-	dst_zone := ""
-	dst_nss := types.ArrayString{}
+	var (
+		dst_host   = "127.0.0.1"
+		dst_port   = "6378"
+		dst_auth   = ""
+		batch      = 1000
+		checkpoint = ""
+		verify     = false
+	)
+
+	mg := &migrator{
+		src_zone:    "local",
+		zone_fields: types.ArrayString{"zone", "zone_id", "zone_name"},
+		workers:     4,
+		num:         map[string]int{},
+		prefixes:    map[string]int{},
+	}
 
 	if v, ok := hflag.Value("dst_host"); ok {
 		dst_host = v.String()
@@ -69,15 +125,45 @@ func main() {
 	if v, ok := hflag.Value("dst_auth"); ok {
 		dst_auth = v.String()
 	}
-	if v, ok := hflag.Value("dst_auth"); ok {
-		dst_auth = v.String()
+	if v, ok := hflag.Value("src_zone"); ok && v.String() != "" {
+		mg.src_zone = v.String()
 	}
 	if v, ok := hflag.Value("dst_zone"); ok {
-		dst_zone = v.String()
+		mg.dst_zone = v.String()
+	}
+	if v, ok := hflag.Value("zone_fields"); ok {
+		mg.zone_fields = types.ArrayString{}
+		for _, name := range strings.Split(v.String(), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				mg.zone_fields.Set(name)
+			}
+		}
+	}
+	if v, ok := hflag.Value("namespaces"); ok {
+		for _, ns := range strings.Split(v.String(), ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				mg.nss.Set(ns)
+			}
+		}
+	}
+	if _, ok := hflag.Value("dry-run"); ok {
+		mg.dry_run = true
+	}
+	if v, ok := hflag.Value("batch"); ok && v.Int() > 0 {
+		batch = v.Int()
+	}
+	if v, ok := hflag.Value("workers"); ok && v.Int() > 0 {
+		mg.workers = v.Int()
+	}
+	if v, ok := hflag.Value("checkpoint"); ok {
+		checkpoint = v.String()
+	}
+	if _, ok := hflag.Value("verify"); ok {
+		verify = true
 	}
 
-	if dst_zone == "" {
-		print_err(errors.New("not dst_zone found"))
+	if mg.dst_zone == "" {
+		print_err(errors.New("no dst_zone found"))
 		return
 	}
 
@@ -88,130 +174,401 @@ func main() {
 	dst_cfg.Items.Set("host", dst_host)
 	dst_cfg.Items.Set("port", dst_port)
 	dst_cfg.Items.Set("auth", dst_auth)
+	dst_cfg.Items.Set("max_conn", fmt.Sprintf("%d", mg.workers))
 
 	fmt.Println("Connect")
 	conn, err := lynkstor.NewConnector(lynkstor.NewConfig(dst_cfg))
 	if err != nil {
-		print_err(err.Error())
+		print_err(err)
 		return
 	} else {
 		print_ok("OK")
 	}
 	defer conn.Close()
-
-	offset := []byte{uint8(8)}
-	cutset := []byte{uint8(8)}
-	limit := 1000
-	for {
-		rs := kvdb.RawScan(offset, cutset, limit)
-		for _, v := range rs.KvList() {
-			fmt.Println(v.Key)
-			offset = v.Key
-		}
-		if rs.KvLen() < limit {
-			break
+	mg.conn = conn
+
+	var (
+		offset = []byte{kvgo_ns_prog}
+		cutset = []byte{kvgo_ns_prog}
+		last   []byte
+		start  = time.Now()
+	)
+
+	if checkpoint != "" {
+		if bs, err := ioutil.ReadFile(checkpoint); err == nil {
+			if key, err := hex.DecodeString(strings.TrimSpace(string(bs))); err == nil && len(key) > 0 {
+				offset, last = key, key
+				fmt.Println("Resume after", hex.EncodeToString(key))
+			}
 		}
 	}
 
-	offset = []byte{uint8(36)}
-	cutset = []byte{uint8(36)}
-	num_ok, num_er, num_bytes, num_json, num_prog := 0, 0, 0, 0, 0
-	prefixs := types.ArrayString{}
+	// once a key failed, the checkpoint stays before it, so that a later
+	// run copies it again
+	failed := false
 
 	for {
-		rs := kvdb.RawScan(offset, cutset, limit)
-		for _, v := range rs.KvList() {
-			offset = v.Key
 
-			value := skv.ValueBytes(v.Value).Bytes()
-			if len(value) < 1 {
-				log.Fatal("value error")
+		rs := kvdb.RawScan(offset, cutset, batch)
+		ls := rs.KvList()
+
+		var (
+			entries = []*lynkstor.RepEntry{}
+			scanned = []*scanEntry{}
+		)
+		for _, v := range ls {
+
+			if last != nil && string(v.Key) == string(last) {
+				continue
+			}
+			offset, last = v.Key, v.Key
+
+			e := mg.entry(v)
+			if e != nil {
+				entries = append(entries, e)
 			}
+			scanned = append(scanned, &scanEntry{key: v.Key, entry: e})
+		}
 
-			key := bytes_clone(v.Key)
+		errs := mg.put(entries)
 
-			if n := strings.Index(string(key), "local"); n >= 0 {
-				if n+5 >= len(key) {
-					key = append(bytes_clone(key[:n]), []byte(dst_zone)...)
-				} else {
-					key[n-1] = uint8(len(dst_zone))
-					key2 := append(append(bytes_clone(key[:n]), []byte(dst_zone)...), bytes_clone(key[n+5:])...)
-					key = bytes_clone(key2)
+		if checkpoint != "" && !mg.dry_run && !failed {
+			var done []byte
+			for _, v := range scanned {
+				if v.entry != nil && errs[v.entry] {
+					failed = true
+					break
 				}
+				done = v.key
 			}
-
-			if k := skv.ProgKeyDecode(key); k == nil {
-				log.Fatal("invalid key")
-			} else {
-				prefixs.Set(string(k.Items[0].Data))
-				if !dst_nss.Has(string(k.Items[0].Data)) {
-					continue
+			if done != nil {
+				if err := ioutil.WriteFile(checkpoint, []byte(hex.EncodeToString(done)), 0644); err != nil {
+					print_err(err)
+					return
 				}
 			}
+		}
 
-			// print_prog_key(key)
+		if rs.KvLen() < batch {
+			break
+		}
+	}
 
-			switch value[0] {
+	if verify && !mg.dry_run {
+		fmt.Println("Verify")
+		mg.verify(kvdb, batch)
+	}
 
-			case 0:
-				value[0] = 0x00
-				num_bytes++
+	mg.report(time.Since(start))
+}
 
-			case 20:
-				value[0] = uint8(20)
-				num_json++
+// scanEntry is a source key of a batch in scan order, with its entry or nil
+// if the key is skipped.
+type scanEntry struct {
+	key   []byte
+	entry *lynkstor.RepEntry
+}
 
-				js := strings.Replace(string(value[1:]), "\"local\"", "\""+dst_zone+"\"", -1)
-				js = strings.Replace(js, "zm/local", "zm/"+dst_zone, -1)
+// entry returns the key and the replication envelope of a kvgo entry, or
+// nil if the entry is skipped.
+func (mg *migrator) entry(v *skv.ResultEntry) *lynkstor.RepEntry {
 
-				value = []byte{}
-				value = append([]byte{uint8(20)}, []byte(js)...)
+	value := bytes_clone(skv.ValueBytes(v.Value).Bytes())
+	if len(value) < 1 {
+		mg.count("skip_invalid")
+		return nil
+	}
 
-			case 32:
-				num_prog++
-				fmt.Println()
-				print_prog_key(v.Key)
-				fmt.Println(v.Value)
-				fmt.Println()
-				continue
+	key := mg.key(v.Key)
 
-			default:
-				fmt.Println(value)
-				log.Fatal("invalid type")
+	k := skv.ProgKeyDecode(key)
+	if k == nil || len(k.Items) < 1 {
+		mg.count("skip_invalid")
+		return nil
+	}
+
+	prefix := string(k.Items[0].Data)
+	mg.prefixes[prefix]++
+	if len(mg.nss) > 0 && !mg.nss.Has(prefix) {
+		mg.count("skip_ns")
+		return nil
+	}
+
+	switch value[0] {
+
+	case value_ns_bytes:
+		mg.count("bytes")
+
+	case value_ns_json:
+		mg.count("json")
+		js, err := mg.json_zone(value[1:])
+		if err != nil {
+			mg.count("skip_invalid")
+			print_err(fmt.Errorf("invalid json of %s: %s", prog_key_string(key), err))
+			return nil
+		}
+		value = append([]byte{value_ns_json}, js...)
+
+	case value_ns_prog:
+		// prog entries keep their own encoding, both servers read it
+		mg.count("prog")
+
+	default:
+		mg.count("skip_type")
+		print_err(fmt.Errorf("invalid type %d of %s", value[0], prog_key_string(key)))
+		return nil
+	}
+
+	meta2 := &skv.KvMeta{}
+	if meta := v.Meta(); meta != nil && meta.Expired > 0 {
+		// kvgo keeps nanoseconds, lynkstor milliseconds
+		meta2.Expired = meta.Expired / 1e6
+		if meta2.Expired <= uint64(time.Now().UnixNano()/1e6) {
+			mg.count("skip_expired")
+			return nil
+		}
+	}
+
+	return &lynkstor.RepEntry{
+		Key:   key,
+		Meta:  meta2,
+		Value: value,
+	}
+}
+
+// key replaces the key items that equal src_zone with dst_zone.
+func (mg *migrator) key(src []byte) []byte {
+
+	k := skv.ProgKeyDecode(src)
+	if k == nil || len(src) < 1 {
+		return bytes_clone(src)
+	}
+
+	n := 0
+	for i, v := range k.Items {
+		if string(v.Data) == mg.src_zone {
+			k.Items[i] = &skv.KvProgKeyEntry{
+				Type: v.Type,
+				Data: []byte(mg.dst_zone),
 			}
+			n++
+		}
+	}
+	if n == 0 {
+		return bytes_clone(src)
+	}
+
+	return k.Encode(src[0])
+}
+
+// json_zone replaces src_zone in the zone fields of a json value, and in
+// the zone item of zm/<zone> paths. Values without a change are returned
+// as they are.
+func (mg *migrator) json_zone(js []byte) ([]byte, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	obj, n := mg.json_zone_walk(obj, false)
+	if n == 0 {
+		return js, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (mg *migrator) json_zone_walk(obj interface{}, field bool) (interface{}, int) {
+
+	n := 0
+
+	switch v := obj.(type) {
+
+	case map[string]interface{}:
+		for k, sv := range v {
+			var sn int
+			v[k], sn = mg.json_zone_walk(sv, mg.zone_fields.Has(k))
+			n += sn
+		}
+
+	case []interface{}:
+		for i, sv := range v {
+			var sn int
+			v[i], sn = mg.json_zone_walk(sv, field)
+			n += sn
+		}
+
+	case string:
+		zm := "zm/" + mg.src_zone
+		switch {
+		case field && v == mg.src_zone:
+			return mg.dst_zone, 1
+		case v == zm || strings.HasPrefix(v, zm+"/"):
+			return "zm/" + mg.dst_zone + v[len(zm):], 1
+		}
+	}
+
+	return obj, n
+}
+
+// put writes one batch with the configured number of parallel writers and
+// returns the entries that failed.
+func (mg *migrator) put(entries []*lynkstor.RepEntry) map[*lynkstor.RepEntry]bool {
 
-			meta2 := &skv.KvMeta{}
-			meta := v.Meta()
-			if meta != nil {
-				if meta.Expired > 0 {
-					meta2.Expired = meta.Expired / 1e6
+	errs := map[*lynkstor.RepEntry]bool{}
+
+	if mg.dry_run {
+		mg.mu.Lock()
+		mg.num["ok"] += len(entries)
+		mg.mu.Unlock()
+		return errs
+	}
+
+	var (
+		wg    sync.WaitGroup
+		queue = make(chan *lynkstor.RepEntry, len(entries))
+	)
+
+	for _, e := range entries {
+		queue <- e
+	}
+	close(queue)
+
+	for i := 0; i < mg.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range queue {
+				if rs := mg.conn.RepPut(e); rs.OK() {
+					mg.count("ok")
+				} else {
+					mg.mu.Lock()
+					mg.num["er"]++
+					errs[e] = true
+					mg.mu.Unlock()
+					print_err(fmt.Errorf("put %s: %s", prog_key_string(e.Key), rs.ErrorString()))
 				}
 			}
-			meta_enc, err := proto.Marshal(meta2)
-			if err != nil {
-				log.Fatal(err)
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// verify scans the source again and compares every copied value with the
+// one read from the server.
+func (mg *migrator) verify(kvdb *kvgo.Conn, batch int) {
+
+	var (
+		offset = []byte{kvgo_ns_prog}
+		cutset = []byte{kvgo_ns_prog}
+		last   []byte
+		vm     = &migrator{
+			src_zone:    mg.src_zone,
+			dst_zone:    mg.dst_zone,
+			zone_fields: mg.zone_fields,
+			nss:         mg.nss,
+			num:         map[string]int{},
+			prefixes:    map[string]int{},
+		}
+	)
+
+	for {
+
+		rs := kvdb.RawScan(offset, cutset, batch)
+		for _, v := range rs.KvList() {
+
+			if last != nil && string(v.Key) == string(last) {
+				continue
 			}
+			offset, last = v.Key, v.Key
 
-			enc := []byte{0x01, 0}
-			enc[1] = uint8(len(meta_enc))
-			if len(meta_enc) > 0 {
-				enc = append(enc, meta_enc...)
+			e := vm.entry(v)
+			if e == nil {
+				continue
 			}
-			enc = append(enc, value...)
 
-			drs := conn.Cmd("rep_data_put", key, enc)
-			if drs.OK() {
-				num_ok++
-			} else {
-				num_er++
+			de, err := mg.conn.RepGet(e.Key)
+			switch {
+			case err == lynkstor.ErrNotFound:
+				mg.count("verify_missing")
+				print_err(fmt.Errorf("verify %s: not found", prog_key_string(e.Key)))
+			case err != nil:
+				mg.count("verify_error")
+				print_err(fmt.Errorf("verify %s: %s", prog_key_string(e.Key), err))
+			case string(de.Value) != string(e.Value):
+				mg.count("verify_diff")
+				print_err(fmt.Errorf("verify %s: value differs", prog_key_string(e.Key)))
+			default:
+				mg.count("verify_ok")
 			}
 		}
-		if rs.KvLen() < limit {
+
+		if rs.KvLen() < batch {
 			break
 		}
 	}
-	fmt.Println("OK", num_ok, "ER", num_er, "bytes", num_bytes, "prog", num_prog, "json", num_json)
-	// fmt.Println(prefixs)
+}
+
+func (mg *migrator) count(name string) {
+	mg.mu.Lock()
+	mg.num[name]++
+	mg.mu.Unlock()
+}
+
+func (mg *migrator) report(elapsed time.Duration) {
+
+	fmt.Println()
+	if mg.dry_run {
+		fmt.Println("Summary (dry run, nothing written)")
+	} else {
+		fmt.Println("Summary")
+	}
+
+	names := []string{}
+	for k := range mg.num {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Printf("  %-16s %d\n", k, mg.num[k])
+	}
+
+	fmt.Println("  namespaces found:")
+	names = names[:0]
+	for k := range mg.prefixes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		mark := " "
+		if len(mg.nss) == 0 || mg.nss.Has(k) {
+			mark = "*"
+		}
+		fmt.Printf("   %s %-14s %d\n", mark, k, mg.prefixes[k])
+	}
+
+	fmt.Printf("  elapsed          %s\n", elapsed.Round(time.Millisecond))
+
+	if mg.num["er"] > 0 || mg.num["verify_missing"] > 0 ||
+		mg.num["verify_diff"] > 0 || mg.num["verify_error"] > 0 {
+		print_err(errors.New("migration finished with errors"))
+		os.Exit(1)
+	}
+	print_ok("OK")
 }
 
 func bytes_clone(src []byte) []byte {
@@ -222,29 +579,30 @@ func bytes_clone(src []byte) []byte {
 	return dst
 }
 
-var creg = regexp.MustCompile(`([0-9a-zA-Z.]{1,60})`)
+var creg = regexp.MustCompile(`^[0-9a-zA-Z.]{1,60}$`)
 
-func print_prog_key(key []byte) {
+func prog_key_string(key []byte) string {
 
 	k := skv.ProgKeyDecode(key)
 	if k == nil {
-		fmt.Println("invalid prog key")
-		return
+		return fmt.Sprintf("%v", key)
 	}
+
+	s := ""
 	for _, v := range k.Items {
 		if creg.MatchString(string(v.Data)) {
-			fmt.Printf(" / %s", string(v.Data))
+			s += "/" + string(v.Data)
 		} else {
-			fmt.Printf(" / %v", v.Data)
+			s += fmt.Sprintf("/%v", v.Data)
 		}
 	}
-	fmt.Println()
+	return s
 }
 
 func print_ok(msg string) {
 	fmt.Printf("\033[32m  %s \033[0m\n", msg)
 }
 
-func print_err(msg string) {
-	fmt.Printf("\033[31m  %s \033[0m\n", msg)
+func print_err(err error) {
+	fmt.Printf("\033[31m  %s \033[0m\n", err.Error())
 }
@@ -0,0 +1,973 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	rdb_op_slot_info  = 0xf4
+	rdb_op_function   = 0xf5
+	rdb_op_function0  = 0xf6
+	rdb_op_module_aux = 0xf7
+	rdb_op_idle       = 0xf8
+	rdb_op_freq       = 0xf9
+	rdb_op_aux        = 0xfa
+	rdb_op_resizedb   = 0xfb
+	rdb_op_expire_ms  = 0xfc
+	rdb_op_expire     = 0xfd
+	rdb_op_selectdb   = 0xfe
+	rdb_op_eof        = 0xff
+
+	rdb_t_string          = 0
+	rdb_t_list            = 1
+	rdb_t_set             = 2
+	rdb_t_zset            = 3
+	rdb_t_hash            = 4
+	rdb_t_zset2           = 5
+	rdb_t_hash_zipmap     = 9
+	rdb_t_list_ziplist    = 10
+	rdb_t_set_intset      = 11
+	rdb_t_zset_ziplist    = 12
+	rdb_t_hash_ziplist    = 13
+	rdb_t_list_quicklist  = 14
+	rdb_t_hash_listpack   = 16
+	rdb_t_zset_listpack   = 17
+	rdb_t_list_quicklist2 = 18
+	rdb_t_set_listpack    = 20
+
+	// hashes with field expiry, the pre GA types come from 7.4 release
+	// candidates
+	rdb_t_hash_metadata0    = 22
+	rdb_t_hash_listpack_ex0 = 23
+	rdb_t_hash_metadata     = 24
+	rdb_t_hash_listpack_ex  = 25
+
+	rdb_len_6bit  = 0
+	rdb_len_14bit = 1
+	rdb_len_enc   = 3
+	rdb_len_32bit = 0x80
+	rdb_len_64bit = 0x81
+
+	rdb_enc_int8  = 0
+	rdb_enc_int16 = 1
+	rdb_enc_int32 = 2
+	rdb_enc_lzf   = 3
+
+	// field expiry times from this value on mean no expiry
+	rdb_expire_none = 1 << 48
+)
+
+var (
+	err_rdb_format = errors.New("rdb: invalid format")
+)
+
+// rdbReader reads the keys of a redis RDB file. Strings, hashes, sets and
+// sorted sets are decoded in all their encodings, lists are skipped and
+// reported, streams and module types stop the import since their layout
+// can not be skipped.
+type rdbReader struct {
+	r *bufio.Reader
+}
+
+func rdb_open(r io.Reader) (*rdbReader, error) {
+
+	rd := &rdbReader{
+		r: bufio.NewReaderSize(r, 1024*1024),
+	}
+
+	head := make([]byte, 9)
+	if _, err := io.ReadFull(rd.r, head); err != nil {
+		return nil, err_rdb_format
+	}
+	if string(head[:5]) != "REDIS" {
+		return nil, err_rdb_format
+	}
+
+	return rd, nil
+}
+
+// each calls fn with every key of the file, in file order.
+func (rd *rdbReader) each(fn func(e *redisEntry) error) error {
+
+	var (
+		db      = 0
+		expired = int64(0)
+	)
+
+	for {
+
+		op, err := rd.r.ReadByte()
+		if err != nil {
+			return err_rdb_format
+		}
+
+		switch op {
+
+		case rdb_op_eof:
+			return nil
+
+		case rdb_op_selectdb:
+			n, err := rd.len()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+			continue
+
+		case rdb_op_resizedb:
+			if _, err := rd.len(); err != nil {
+				return err
+			}
+			if _, err := rd.len(); err != nil {
+				return err
+			}
+			continue
+
+		case rdb_op_aux:
+			if _, err := rd.string(); err != nil {
+				return err
+			}
+			if _, err := rd.string(); err != nil {
+				return err
+			}
+			continue
+
+		case rdb_op_slot_info:
+			// slot id, slot size, expires slot size
+			for i := 0; i < 3; i++ {
+				if _, err := rd.len(); err != nil {
+					return err
+				}
+			}
+			continue
+
+		case rdb_op_function:
+			if _, err := rd.string(); err != nil {
+				return err
+			}
+			continue
+
+		case rdb_op_function0:
+			// name, engine, optional description and code of the pre GA
+			// function format
+			for i := 0; i < 2; i++ {
+				if _, err := rd.string(); err != nil {
+					return err
+				}
+			}
+			n, err := rd.len()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				if _, err := rd.string(); err != nil {
+					return err
+				}
+			}
+			if _, err := rd.string(); err != nil {
+				return err
+			}
+			continue
+
+		case rdb_op_module_aux:
+			return errors.New("rdb: module data is not supported")
+
+		case rdb_op_expire_ms:
+			bs, err := rd.bytes(8)
+			if err != nil {
+				return err
+			}
+			expired = int64(binary.LittleEndian.Uint64(bs))
+			continue
+
+		case rdb_op_expire:
+			bs, err := rd.bytes(4)
+			if err != nil {
+				return err
+			}
+			expired = int64(binary.LittleEndian.Uint32(bs)) * 1000
+			continue
+
+		case rdb_op_idle:
+			if _, err := rd.len(); err != nil {
+				return err
+			}
+			continue
+
+		case rdb_op_freq:
+			if _, err := rd.r.ReadByte(); err != nil {
+				return err_rdb_format
+			}
+			continue
+		}
+
+		key, err := rd.string()
+		if err != nil {
+			return err
+		}
+
+		e := &redisEntry{
+			db:      db,
+			key:     key,
+			expired: expired,
+		}
+		expired = 0
+
+		if err := rd.value(op, e); err != nil {
+			return fmt.Errorf("rdb: key %q: %s", key, err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (rd *rdbReader) value(t byte, e *redisEntry) error {
+
+	var err error
+
+	switch t {
+
+	case rdb_t_string:
+		e.typ = "string"
+		e.value, err = rd.string()
+
+	case rdb_t_set:
+		e.typ = "set"
+		e.members, err = rd.strings()
+
+	case rdb_t_hash:
+		e.typ = "hash"
+		e.fields, err = rd.strings()
+
+	case rdb_t_zset, rdb_t_zset2:
+		e.typ = "zset"
+		n, err := rd.len()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			member, err := rd.string()
+			if err != nil {
+				return err
+			}
+			score, err := rd.score(t == rdb_t_zset2)
+			if err != nil {
+				return err
+			}
+			e.members = append(e.members, member)
+			e.scores = append(e.scores, score)
+		}
+
+	case rdb_t_hash_zipmap:
+		e.typ = "hash"
+		var bs []byte
+		if bs, err = rd.string(); err == nil {
+			e.fields, err = zipmap_decode(bs)
+		}
+
+	case rdb_t_set_intset:
+		e.typ = "set"
+		var bs []byte
+		if bs, err = rd.string(); err == nil {
+			e.members, err = intset_decode(bs)
+		}
+
+	case rdb_t_set_listpack:
+		e.typ = "set"
+		var bs []byte
+		if bs, err = rd.string(); err == nil {
+			e.members, err = listpack_decode(bs)
+		}
+
+	case rdb_t_hash_ziplist:
+		e.typ = "hash"
+		var bs []byte
+		if bs, err = rd.string(); err == nil {
+			e.fields, err = ziplist_decode(bs)
+		}
+
+	case rdb_t_hash_listpack:
+		e.typ = "hash"
+		var bs []byte
+		if bs, err = rd.string(); err == nil {
+			e.fields, err = listpack_decode(bs)
+		}
+
+	case rdb_t_hash_metadata, rdb_t_hash_metadata0:
+		e.typ = "hash"
+		return rd.hash_metadata(t, e)
+
+	case rdb_t_hash_listpack_ex, rdb_t_hash_listpack_ex0:
+		e.typ = "hash"
+		return rd.hash_listpack_ex(t, e)
+
+	case rdb_t_zset_ziplist, rdb_t_zset_listpack:
+		e.typ = "zset"
+		var bs []byte
+		if bs, err = rd.string(); err != nil {
+			return err
+		}
+		var ls [][]byte
+		if t == rdb_t_zset_ziplist {
+			ls, err = ziplist_decode(bs)
+		} else {
+			ls, err = listpack_decode(bs)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ls)%2 != 0 {
+			return err_rdb_format
+		}
+		for i := 0; i < len(ls); i += 2 {
+			score, err := strconv.ParseFloat(string(ls[i+1]), 64)
+			if err != nil {
+				return err_rdb_format
+			}
+			e.members = append(e.members, ls[i])
+			e.scores = append(e.scores, score)
+		}
+
+	case rdb_t_list, rdb_t_list_quicklist:
+		e.typ = "list"
+		_, err = rd.strings()
+
+	case rdb_t_list_ziplist:
+		e.typ = "list"
+		_, err = rd.string()
+
+	case rdb_t_list_quicklist2:
+		e.typ = "list"
+		n, err := rd.len()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := rd.len(); err != nil {
+				return err
+			}
+			if _, err := rd.string(); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("value type %d is not supported", t)
+	}
+
+	return err
+}
+
+// hash_metadata reads a hash table with field expiry. The GA format stores
+// the expiry times relative to the minimum one, ttl - min + 1, 0 for none.
+func (rd *rdbReader) hash_metadata(t byte, e *redisEntry) error {
+
+	min := int64(0)
+	if t == rdb_t_hash_metadata {
+		bs, err := rd.bytes(8)
+		if err != nil {
+			return err
+		}
+		min = int64(binary.LittleEndian.Uint64(bs))
+	}
+
+	n, err := rd.len()
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < n; i++ {
+
+		ttl := int64(0)
+		if t == rdb_t_hash_metadata {
+			v, err := rd.len()
+			if err != nil {
+				return err
+			}
+			if v > 0 {
+				ttl = int64(v) + min - 1
+			}
+		} else {
+			bs, err := rd.bytes(8)
+			if err != nil {
+				return err
+			}
+			ttl = int64(binary.LittleEndian.Uint64(bs))
+		}
+
+		field, err := rd.string()
+		if err != nil {
+			return err
+		}
+		value, err := rd.string()
+		if err != nil {
+			return err
+		}
+		e.hash_field(field, value, ttl)
+	}
+
+	return nil
+}
+
+// hash_listpack_ex reads a listpack of field, value, expiry time triplets.
+func (rd *rdbReader) hash_listpack_ex(t byte, e *redisEntry) error {
+
+	if t == rdb_t_hash_listpack_ex {
+		// the minimum expiry time of the fields
+		if _, err := rd.bytes(8); err != nil {
+			return err
+		}
+	}
+
+	bs, err := rd.string()
+	if err != nil {
+		return err
+	}
+	ls, err := listpack_decode(bs)
+	if err != nil {
+		return err
+	}
+	if len(ls)%3 != 0 {
+		return err_rdb_format
+	}
+
+	for i := 0; i < len(ls); i += 3 {
+		ttl, err := strconv.ParseInt(string(ls[i+2]), 10, 64)
+		if err != nil {
+			return err_rdb_format
+		}
+		e.hash_field(ls[i], ls[i+1], ttl)
+	}
+
+	return nil
+}
+
+func (rd *rdbReader) bytes(n int) ([]byte, error) {
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(rd.r, bs); err != nil {
+		return nil, err_rdb_format
+	}
+	return bs, nil
+}
+
+// len_enc reads a length, or the special encoding of a string if enc is
+// true.
+func (rd *rdbReader) len_enc() (uint64, bool, error) {
+
+	b, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, false, err_rdb_format
+	}
+
+	switch b >> 6 {
+
+	case rdb_len_6bit:
+		return uint64(b & 0x3f), false, nil
+
+	case rdb_len_14bit:
+		b2, err := rd.r.ReadByte()
+		if err != nil {
+			return 0, false, err_rdb_format
+		}
+		return uint64(b&0x3f)<<8 | uint64(b2), false, nil
+
+	case rdb_len_enc:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	switch b {
+
+	case rdb_len_32bit:
+		bs, err := rd.bytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(bs)), false, nil
+
+	case rdb_len_64bit:
+		bs, err := rd.bytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(bs), false, nil
+	}
+
+	return 0, false, err_rdb_format
+}
+
+func (rd *rdbReader) len() (uint64, error) {
+	n, enc, err := rd.len_enc()
+	if err == nil && enc {
+		err = err_rdb_format
+	}
+	return n, err
+}
+
+func (rd *rdbReader) string() ([]byte, error) {
+
+	n, enc, err := rd.len_enc()
+	if err != nil {
+		return nil, err
+	}
+
+	if !enc {
+		return rd.bytes(int(n))
+	}
+
+	switch n {
+
+	case rdb_enc_int8:
+		bs, err := rd.bytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(bs[0])))), nil
+
+	case rdb_enc_int16:
+		bs, err := rd.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(bs))))), nil
+
+	case rdb_enc_int32:
+		bs, err := rd.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(bs))))), nil
+
+	case rdb_enc_lzf:
+		clen, err := rd.len()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := rd.len()
+		if err != nil {
+			return nil, err
+		}
+		bs, err := rd.bytes(int(clen))
+		if err != nil {
+			return nil, err
+		}
+		return lzf_decompress(bs, int(ulen))
+	}
+
+	return nil, err_rdb_format
+}
+
+func (rd *rdbReader) strings() ([][]byte, error) {
+	n, err := rd.len()
+	if err != nil {
+		return nil, err
+	}
+	ls := [][]byte{}
+	for i := uint64(0); i < n; i++ {
+		bs, err := rd.string()
+		if err != nil {
+			return nil, err
+		}
+		ls = append(ls, bs)
+	}
+	return ls, nil
+}
+
+func (rd *rdbReader) score(binary_double bool) (float64, error) {
+
+	if binary_double {
+		bs, err := rd.bytes(8)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(bs)), nil
+	}
+
+	n, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, err_rdb_format
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	bs, err := rd.bytes(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(bs), 64)
+}
+
+func lzf_decompress(in []byte, ulen int) ([]byte, error) {
+
+	out := make([]byte, 0, ulen)
+
+	for i := 0; i < len(in); {
+
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, err_rdb_format
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, err_rdb_format
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, err_rdb_format
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, err_rdb_format
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != ulen {
+		return nil, err_rdb_format
+	}
+
+	return out, nil
+}
+
+func ziplist_decode(bs []byte) ([][]byte, error) {
+
+	if len(bs) < 11 {
+		return nil, err_rdb_format
+	}
+
+	var (
+		ls = [][]byte{}
+		i  = 10
+	)
+
+	for i < len(bs) && bs[i] != 0xff {
+
+		// previous entry length
+		if bs[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(bs) {
+			return nil, err_rdb_format
+		}
+
+		enc := bs[i]
+		i++
+
+		var (
+			n   = 0
+			val []byte
+		)
+
+		switch {
+
+		case enc>>6 == 0:
+			n = int(enc & 0x3f)
+
+		case enc>>6 == 1:
+			if i >= len(bs) {
+				return nil, err_rdb_format
+			}
+			n = int(enc&0x3f)<<8 | int(bs[i])
+			i++
+
+		case enc == 0x80:
+			if i+4 > len(bs) {
+				return nil, err_rdb_format
+			}
+			n = int(binary.BigEndian.Uint32(bs[i:]))
+			i += 4
+
+		default:
+			var (
+				v    int64
+				size = 0
+			)
+			switch enc {
+			case 0xc0:
+				size = 2
+			case 0xd0:
+				size = 4
+			case 0xe0:
+				size = 8
+			case 0xf0:
+				size = 3
+			case 0xfe:
+				size = 1
+			default:
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, err_rdb_format
+				}
+				v = int64(enc&0x0f) - 1
+			}
+			if i+size > len(bs) {
+				return nil, err_rdb_format
+			}
+			switch size {
+			case 1:
+				v = int64(int8(bs[i]))
+			case 2:
+				v = int64(int16(binary.LittleEndian.Uint16(bs[i:])))
+			case 3:
+				v = int64(int32(uint32(bs[i])<<8|uint32(bs[i+1])<<16|uint32(bs[i+2])<<24) >> 8)
+			case 4:
+				v = int64(int32(binary.LittleEndian.Uint32(bs[i:])))
+			case 8:
+				v = int64(binary.LittleEndian.Uint64(bs[i:]))
+			}
+			i += size
+			val = []byte(strconv.FormatInt(v, 10))
+		}
+
+		if val == nil {
+			if i+n > len(bs) {
+				return nil, err_rdb_format
+			}
+			val = bs[i : i+n]
+			i += n
+		}
+
+		ls = append(ls, val)
+	}
+
+	return ls, nil
+}
+
+func listpack_decode(bs []byte) ([][]byte, error) {
+
+	if len(bs) < 7 {
+		return nil, err_rdb_format
+	}
+
+	var (
+		ls = [][]byte{}
+		i  = 6
+	)
+
+	for i < len(bs) && bs[i] != 0xff {
+
+		var (
+			start = i
+			enc   = bs[i]
+			val   []byte
+			v     int64
+			n     = 0
+			size  = 0
+		)
+		i++
+
+		switch {
+
+		case enc&0x80 == 0:
+			val = []byte(strconv.Itoa(int(enc & 0x7f)))
+
+		case enc&0xc0 == 0x80:
+			n = int(enc & 0x3f)
+
+		case enc&0xe0 == 0xc0:
+			if i >= len(bs) {
+				return nil, err_rdb_format
+			}
+			u := int64(enc&0x1f)<<8 | int64(bs[i])
+			i++
+			if u >= 1<<12 {
+				u -= 1 << 13
+			}
+			val = []byte(strconv.FormatInt(u, 10))
+
+		case enc&0xf0 == 0xe0:
+			if i >= len(bs) {
+				return nil, err_rdb_format
+			}
+			n = int(enc&0x0f)<<8 | int(bs[i])
+			i++
+
+		case enc == 0xf0:
+			if i+4 > len(bs) {
+				return nil, err_rdb_format
+			}
+			n = int(binary.LittleEndian.Uint32(bs[i:]))
+			i += 4
+
+		case enc == 0xf1:
+			size = 2
+		case enc == 0xf2:
+			size = 3
+		case enc == 0xf3:
+			size = 4
+		case enc == 0xf4:
+			size = 8
+
+		default:
+			return nil, err_rdb_format
+		}
+
+		if size > 0 {
+			if i+size > len(bs) {
+				return nil, err_rdb_format
+			}
+			switch size {
+			case 2:
+				v = int64(int16(binary.LittleEndian.Uint16(bs[i:])))
+			case 3:
+				v = int64(int32(uint32(bs[i])<<8|uint32(bs[i+1])<<16|uint32(bs[i+2])<<24) >> 8)
+			case 4:
+				v = int64(int32(binary.LittleEndian.Uint32(bs[i:])))
+			case 8:
+				v = int64(binary.LittleEndian.Uint64(bs[i:]))
+			}
+			i += size
+			val = []byte(strconv.FormatInt(v, 10))
+		}
+
+		if val == nil {
+			if i+n > len(bs) {
+				return nil, err_rdb_format
+			}
+			val = bs[i : i+n]
+			i += n
+		}
+
+		// skip the back length of the entry
+		switch l := i - start; {
+		case l < 128:
+			i++
+		case l < 16384:
+			i += 2
+		case l < 2097152:
+			i += 3
+		case l < 268435456:
+			i += 4
+		default:
+			i += 5
+		}
+
+		ls = append(ls, val)
+	}
+
+	return ls, nil
+}
+
+func intset_decode(bs []byte) ([][]byte, error) {
+
+	if len(bs) < 8 {
+		return nil, err_rdb_format
+	}
+
+	var (
+		size = int(binary.LittleEndian.Uint32(bs))
+		num  = int(binary.LittleEndian.Uint32(bs[4:]))
+		ls   = [][]byte{}
+	)
+
+	if (size != 2 && size != 4 && size != 8) || 8+size*num > len(bs) {
+		return nil, err_rdb_format
+	}
+
+	for i := 0; i < num; i++ {
+		p := bs[8+i*size:]
+		var v int64
+		switch size {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		ls = append(ls, []byte(strconv.FormatInt(v, 10)))
+	}
+
+	return ls, nil
+}
+
+func zipmap_decode(bs []byte) ([][]byte, error) {
+
+	var (
+		ls = [][]byte{}
+		i  = 1
+	)
+
+	read_len := func() (int, error) {
+		if i >= len(bs) {
+			return 0, err_rdb_format
+		}
+		if bs[i] < 254 {
+			i++
+			return int(bs[i-1]), nil
+		}
+		if bs[i] == 254 && i+5 <= len(bs) {
+			n := int(binary.LittleEndian.Uint32(bs[i+1:]))
+			i += 5
+			return n, nil
+		}
+		return 0, err_rdb_format
+	}
+
+	for i < len(bs) && bs[i] != 0xff {
+
+		n, err := read_len()
+		if err != nil || i+n > len(bs) {
+			return nil, err_rdb_format
+		}
+		field := bs[i : i+n]
+		i += n
+
+		n, err = read_len()
+		if err != nil || i+1+n > len(bs) {
+			return nil, err_rdb_format
+		}
+		free := int(bs[i])
+		i++
+		value := bs[i : i+n]
+		i += n + free
+
+		ls = append(ls, field, value)
+	}
+
+	return ls, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func strs(ls [][]byte) []string {
+	ss := []string{}
+	for _, v := range ls {
+		ss = append(ss, string(v))
+	}
+	return ss
+}
+
+func rdb_str(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func rdb_u64(v uint64) []byte {
+	bs := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bs, v)
+	return bs
+}
+
+func TestLzfDecompress(t *testing.T) {
+
+	// 3 literal bytes, then a back reference of 3 bytes to offset 3
+	out, err := lzf_decompress([]byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, 6)
+	if err != nil || string(out) != "abcabc" {
+		t.Fatalf("lzf %q %v", out, err)
+	}
+
+	if _, err := lzf_decompress([]byte{0x20, 0x05}, 3); err == nil {
+		t.Fatal("lzf reference before the start not rejected")
+	}
+	if _, err := lzf_decompress([]byte{0x02, 'a', 'b', 'c'}, 4); err == nil {
+		t.Fatal("lzf length mismatch not rejected")
+	}
+}
+
+func TestZiplistDecode(t *testing.T) {
+
+	bs := make([]byte, 10)
+	bs = append(bs, 0x00, 0x03, 'f', 'o', 'o') // string
+	bs = append(bs, 0x05, 0xf2)                // immediate 1
+	bs = append(bs, 0x02, 0xc0, 0x2c, 0x01)    // int16 300
+	bs = append(bs, 0x04, 0xfe, 0xf6)          // int8 -10
+	bs = append(bs, 0xff)
+
+	ls, err := ziplist_decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"foo", "1", "300", "-10"}; !reflect.DeepEqual(strs(ls), want) {
+		t.Fatalf("ziplist %v, want %v", strs(ls), want)
+	}
+
+	if _, err := ziplist_decode(bs[:14]); err == nil {
+		t.Fatal("truncated ziplist not rejected")
+	}
+}
+
+func TestListpackDecode(t *testing.T) {
+
+	bs := make([]byte, 6)
+	bs = append(bs, 0x05, 0x01)             // 7 bit uint 5
+	bs = append(bs, 0x82, 'a', 'b', 0x03)   // 6 bit string
+	bs = append(bs, 0xdf, 0xff, 0x02)       // 13 bit int -1
+	bs = append(bs, 0xf1, 0x2c, 0x01, 0x03) // int16 300
+	bs = append(bs, 0xf4)                   // int64
+	bs = append(bs, rdb_u64(4102444800000)...)
+	bs = append(bs, 0x09, 0xff)
+
+	ls, err := listpack_decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"5", "ab", "-1", "300", "4102444800000"}; !reflect.DeepEqual(strs(ls), want) {
+		t.Fatalf("listpack %v, want %v", strs(ls), want)
+	}
+
+	if _, err := listpack_decode([]byte{0, 0, 0, 0, 0, 0, 0xf5, 0xff}); err == nil {
+		t.Fatal("invalid listpack encoding not rejected")
+	}
+}
+
+func TestIntsetDecode(t *testing.T) {
+
+	bs := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 0x07, 0x00}
+
+	ls, err := intset_decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-1", "7"}; !reflect.DeepEqual(strs(ls), want) {
+		t.Fatalf("intset %v, want %v", strs(ls), want)
+	}
+
+	if _, err := intset_decode(bs[:10]); err == nil {
+		t.Fatal("truncated intset not rejected")
+	}
+}
+
+func TestZipmapDecode(t *testing.T) {
+
+	bs := []byte{1, 3, 'f', 'o', 'o', 3, 1, 'b', 'a', 'r', 'x', 0xff}
+
+	ls, err := zipmap_decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"foo", "bar"}; !reflect.DeepEqual(strs(ls), want) {
+		t.Fatalf("zipmap %v, want %v", strs(ls), want)
+	}
+}
+
+func TestRdbEach(t *testing.T) {
+
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0012")
+
+	buf.WriteByte(rdb_op_aux)
+	buf.Write(rdb_str("redis-ver"))
+	buf.Write(rdb_str("7.4.0"))
+
+	buf.Write([]byte{rdb_op_slot_info, 1, 2, 0})
+
+	buf.WriteByte(rdb_op_function0)
+	buf.Write(rdb_str("lib"))
+	buf.Write(rdb_str("LUA"))
+	buf.WriteByte(1)
+	buf.Write(rdb_str("desc"))
+	buf.Write(rdb_str("code"))
+
+	buf.Write([]byte{rdb_op_selectdb, 0, rdb_op_resizedb, 3, 1})
+
+	// string with expiry
+	buf.WriteByte(rdb_op_expire_ms)
+	buf.Write(rdb_u64(4102444800000))
+	buf.WriteByte(rdb_t_string)
+	buf.Write(rdb_str("s1"))
+	buf.Write(rdb_str("v1"))
+
+	// listpack hash with field expiry, f2 expires
+	lp := make([]byte, 6)
+	lp = append(lp, 0x82, 'f', '1', 0x03, 0x82, 'v', '1', 0x03, 0x00, 0x01)
+	lp = append(lp, 0x82, 'f', '2', 0x03, 0x82, 'v', '2', 0x03, 0xf4)
+	lp = append(lp, rdb_u64(4102444800000)...)
+	lp = append(lp, 0x09, 0xff)
+	buf.WriteByte(rdb_t_hash_listpack_ex)
+	buf.Write(rdb_str("h1"))
+	buf.Write(rdb_u64(4102444800000))
+	buf.WriteByte(byte(len(lp)))
+	buf.Write(lp)
+
+	// hash table with field expiry relative to the minimum
+	buf.WriteByte(rdb_t_hash_metadata)
+	buf.Write(rdb_str("h2"))
+	buf.Write(rdb_u64(1000))
+	buf.WriteByte(2)
+	buf.WriteByte(0)
+	buf.Write(rdb_str("a"))
+	buf.Write(rdb_str("1"))
+	buf.WriteByte(5)
+	buf.Write(rdb_str("b"))
+	buf.Write(rdb_str("2"))
+
+	buf.Write([]byte{rdb_op_selectdb, 1})
+	buf.WriteByte(rdb_t_set_intset)
+	buf.Write(rdb_str("s2"))
+	buf.Write(rdb_str(string([]byte{2, 0, 0, 0, 1, 0, 0, 0, 0x07, 0x00})))
+
+	buf.WriteByte(rdb_op_eof)
+	buf.Write(make([]byte, 8))
+
+	rd, err := rdb_open(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls := []*redisEntry{}
+	if err := rd.each(func(e *redisEntry) error {
+		ls = append(ls, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ls) != 4 {
+		t.Fatalf("entries %d, want 4", len(ls))
+	}
+
+	if e := ls[0]; e.typ != "string" || string(e.value) != "v1" || e.expired != 4102444800000 {
+		t.Fatalf("string %+v", e)
+	}
+
+	if e := ls[1]; e.typ != "hash" || !reflect.DeepEqual(strs(e.fields), []string{"f1", "v1", "f2", "v2"}) ||
+		!reflect.DeepEqual(e.ttls, []int64{0, 4102444800000}) {
+		t.Fatalf("hash listpack ex %s %v", strs(e.fields), e.ttls)
+	}
+
+	if e := ls[2]; e.typ != "hash" || !reflect.DeepEqual(strs(e.fields), []string{"a", "1", "b", "2"}) ||
+		!reflect.DeepEqual(e.ttls, []int64{0, 1004}) {
+		t.Fatalf("hash metadata %s %v", strs(e.fields), e.ttls)
+	}
+
+	if e := ls[3]; e.db != 1 || e.typ != "set" || !reflect.DeepEqual(strs(e.members), []string{"7"}) {
+		t.Fatalf("set %+v", e)
+	}
+}
+
+func TestRdbModuleAux(t *testing.T) {
+
+	rd, err := rdb_open(bytes.NewReader([]byte("REDIS0012\xf7")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rd.each(func(e *redisEntry) error { return nil }); err == nil {
+		t.Fatal("module aux data not reported")
+	}
+}
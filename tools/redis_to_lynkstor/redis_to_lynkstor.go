@@ -0,0 +1,609 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// redis_to_lynkstor imports the keys of a redis RDB file, or of a live
+// redis compatible server, into lynkstor.
+//
+//	--src_rdb      RDB file to read
+//	--src_host     --src_port --src_auth of a live server, read with SCAN
+//	--src_db       database number, default 0, -1 for all databases
+//	--match        glob pattern of keys to import, default *
+//	--dst_host     --dst_port --dst_auth of the lynkstor server
+//	--prog_prefix  prog key path placed before hash, set and zset keys
+//	--set_layout   member (default) or json
+//	--zset_layout  member (default), score or json
+//	--unmapped     file to list the keys that could not be mapped
+//	--dry-run      read and count only, write nothing
+//
+// Strings become raw keys with their TTL sent as PX. The other types
+// become prog keys under [prefix..., key]:
+//
+//	hash             [key, field] = value, with the expiry time of the field
+//	set  member      [key, member] = member
+//	set  json        [key] = [member, ...]
+//	zset member      [key, member] = score
+//	zset score       [key, score, member] = score, ordered by score
+//	zset json        [key] = [{member, score}, ...]
+//
+// Lists, streams and module types are reported as unmapped.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hooto/hflag4g/hflag"
+	"github.com/lynkdb/iomix/connect"
+	"github.com/lynkdb/iomix/skv"
+
+	"github.com/lynkdb/lynkstorgo/lynkstor"
+)
+
+type redisEntry struct {
+	db      int
+	key     []byte
+	typ     string
+	expired int64 // unix milliseconds, 0 for never
+	value   []byte
+	fields  [][]byte // field, value, ...
+	ttls    []int64  // expiry time of each field in unix milliseconds, or nil
+	members [][]byte
+	scores  []float64
+}
+
+// hash_field adds a field with its expiry time, 0 for never.
+func (e *redisEntry) hash_field(field, value []byte, ttl int64) {
+	if ttl < 0 || ttl >= rdb_expire_none {
+		ttl = 0
+	}
+	if ttl > 0 && e.ttls == nil {
+		e.ttls = make([]int64, len(e.fields)/2)
+	}
+	e.fields = append(e.fields, field, value)
+	if e.ttls != nil {
+		e.ttls = append(e.ttls, ttl)
+	}
+}
+
+type zsetItem struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+type importer struct {
+	conn        *lynkstor.Connector
+	prefix      []string
+	set_layout  string
+	zset_layout string
+	match       string
+	db          int
+	dry_run     bool
+	num         map[string]int
+	unmapped    []string
+}
+
+func main() {
+
+	var (
+		src_rdb  = ""
+		src_host = ""
+		src_port = "6379"
+		src_auth = ""
+		dst_host = "127.0.0.1"
+		dst_port = "6378"
+		dst_auth = ""
+		unmapped = ""
+	)
+
+	im := &importer{
+		set_layout:  "member",
+		zset_layout: "member",
+		match:       "*",
+		num:         map[string]int{},
+	}
+
+	if v, ok := hflag.Value("src_rdb"); ok {
+		src_rdb = v.String()
+	}
+	if v, ok := hflag.Value("src_host"); ok {
+		src_host = v.String()
+	}
+	if v, ok := hflag.Value("src_port"); ok {
+		src_port = v.String()
+	}
+	if v, ok := hflag.Value("src_auth"); ok {
+		src_auth = v.String()
+	}
+	if v, ok := hflag.Value("src_db"); ok {
+		im.db = v.Int()
+	}
+	if v, ok := hflag.Value("match"); ok && v.String() != "" {
+		im.match = v.String()
+	}
+	if v, ok := hflag.Value("dst_host"); ok {
+		dst_host = v.String()
+	}
+	if v, ok := hflag.Value("dst_port"); ok {
+		dst_port = v.String()
+	}
+	if v, ok := hflag.Value("dst_auth"); ok {
+		dst_auth = v.String()
+	}
+	if v, ok := hflag.Value("prog_prefix"); ok {
+		for _, s := range strings.Split(strings.Trim(v.String(), "/"), "/") {
+			if s != "" {
+				im.prefix = append(im.prefix, s)
+			}
+		}
+	}
+	if v, ok := hflag.Value("set_layout"); ok {
+		im.set_layout = v.String()
+	}
+	if v, ok := hflag.Value("zset_layout"); ok {
+		im.zset_layout = v.String()
+	}
+	if v, ok := hflag.Value("unmapped"); ok {
+		unmapped = v.String()
+	}
+	if _, ok := hflag.Value("dry-run"); ok {
+		im.dry_run = true
+	}
+
+	switch im.set_layout {
+	case "member", "json":
+	default:
+		log.Fatal("invalid --set_layout " + im.set_layout)
+	}
+	switch im.zset_layout {
+	case "member", "score", "json":
+	default:
+		log.Fatal("invalid --zset_layout " + im.zset_layout)
+	}
+
+	if (src_rdb == "") == (src_host == "") {
+		log.Fatal("one of --src_rdb or --src_host is required")
+	}
+
+	if !im.dry_run {
+		dst_cfg := connect.ConnOptions{
+			Name:      "lynkstor/go/redis",
+			Connector: "iomix/skv/Connector",
+		}
+		dst_cfg.Items.Set("host", dst_host)
+		dst_cfg.Items.Set("port", dst_port)
+		dst_cfg.Items.Set("auth", dst_auth)
+
+		fmt.Println("Connect")
+		conn, err := lynkstor.NewConnector(lynkstor.NewConfig(dst_cfg))
+		if err != nil {
+			print_err(err)
+			return
+		}
+		print_ok("OK")
+		defer conn.Close()
+		im.conn = conn
+	}
+
+	var (
+		start = time.Now()
+		err   error
+	)
+
+	if src_rdb != "" {
+		err = im.rdb_import(src_rdb)
+	} else {
+		err = im.scan_import(src_host+":"+src_port, src_auth)
+	}
+	if err != nil {
+		print_err(err)
+	}
+
+	if unmapped != "" && len(im.unmapped) > 0 {
+		if err := ioutil.WriteFile(unmapped, []byte(strings.Join(im.unmapped, "\n")+"\n"), 0644); err != nil {
+			print_err(err)
+		}
+	}
+
+	im.report(time.Since(start), unmapped == "")
+
+	if err != nil || im.num["error"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func (im *importer) rdb_import(name string) error {
+
+	fp, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	rd, err := rdb_open(fp)
+	if err != nil {
+		return err
+	}
+
+	return rd.each(func(e *redisEntry) error {
+		if im.db >= 0 && e.db != im.db {
+			im.num["skip_db"]++
+			return nil
+		}
+		if ok, _ := path.Match(im.match, string(e.key)); !ok {
+			im.num["skip_match"]++
+			return nil
+		}
+		im.put(e)
+		return nil
+	})
+}
+
+func (im *importer) scan_import(addr, auth string) error {
+
+	src, err := resp_dial(addr, auth, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dbs := []int{im.db}
+	if im.db < 0 {
+		if dbs, err = scan_dbs(src); err != nil {
+			return err
+		}
+	}
+
+	for _, db := range dbs {
+		if _, err := src.do("SELECT", strconv.Itoa(db)); err != nil {
+			return fmt.Errorf("db %d: %s", db, err)
+		}
+		if err := im.scan_db(src, db); err != nil {
+			return fmt.Errorf("db %d: %s", db, err)
+		}
+	}
+
+	return nil
+}
+
+// scan_dbs returns the numbers of the databases that hold keys, by INFO
+// keyspace, or all configured databases.
+func scan_dbs(src *respConn) ([]int, error) {
+
+	rs, err := src.do("INFO", "keyspace")
+	if err != nil {
+		return nil, err
+	}
+
+	dbs := []int{}
+	for _, line := range strings.Split(string(resp_bytes(rs)), "\n") {
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+		if n := strings.IndexByte(line, ':'); n > 2 {
+			if db, err := strconv.Atoi(line[2:n]); err == nil {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+	if len(dbs) > 0 {
+		return dbs, nil
+	}
+
+	n := 16
+	if ls, err := resp_list(src.do("CONFIG", "GET", "databases")); err == nil && len(ls) == 2 {
+		if v, err := strconv.Atoi(string(ls[1])); err == nil && v > 0 {
+			n = v
+		}
+	}
+	for i := 0; i < n; i++ {
+		dbs = append(dbs, i)
+	}
+	return dbs, nil
+}
+
+func (im *importer) scan_db(src *respConn, db int) error {
+
+	cursor := "0"
+
+	for {
+
+		rs, err := src.do("SCAN", cursor, "MATCH", im.match, "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		ls, ok := rs.([]interface{})
+		if !ok || len(ls) != 2 {
+			return err_resp_parse
+		}
+		keys, err := resp_list(ls[1], nil)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			e, err := scan_entry(src, key)
+			if err != nil {
+				return fmt.Errorf("key %q: %s", key, err)
+			}
+			if e != nil {
+				e.db = db
+				im.put(e)
+			}
+		}
+
+		if cursor = string(resp_bytes(ls[0])); cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// scan_entry reads one key of a live server, nil if it has gone.
+func scan_entry(src *respConn, key []byte) (*redisEntry, error) {
+
+	rs, err := src.do("TYPE", string(key))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &redisEntry{
+		key: key,
+		typ: string(resp_bytes(rs)),
+	}
+
+	if e.typ == "none" {
+		return nil, nil
+	}
+
+	rs, err = src.do("PTTL", string(key))
+	if err != nil {
+		return nil, err
+	}
+	if ttl, ok := rs.(int64); ok && ttl > 0 {
+		e.expired = time.Now().UnixNano()/1e6 + ttl
+	}
+
+	switch e.typ {
+
+	case "string":
+		rs, err = src.do("GET", string(key))
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			return nil, nil
+		}
+		e.value = resp_bytes(rs)
+
+	case "hash":
+		e.fields, err = resp_list(src.do("HGETALL", string(key)))
+
+	case "set":
+		e.members, err = resp_list(src.do("SMEMBERS", string(key)))
+
+	case "zset":
+		ls, err := resp_list(src.do("ZRANGE", string(key), "0", "-1", "WITHSCORES"))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(ls); i += 2 {
+			score, err := strconv.ParseFloat(string(ls[i+1]), 64)
+			if err != nil {
+				return nil, err
+			}
+			e.members = append(e.members, ls[i])
+			e.scores = append(e.scores, score)
+		}
+	}
+
+	return e, err
+}
+
+func (im *importer) prog_key(items ...[]byte) skv.KvProgKey {
+	k := skv.KvProgKey{}
+	for _, v := range im.prefix {
+		k.Append(v)
+	}
+	for _, v := range items {
+		k.Append(v)
+	}
+	return k
+}
+
+func (im *importer) put(e *redisEntry) {
+
+	now := time.Now().UnixNano() / 1e6
+	if e.expired > 0 && e.expired <= now {
+		im.num["skip_expired"]++
+		return
+	}
+
+	im.num[e.typ]++
+
+	var (
+		keys   []skv.KvProgKey
+		values []interface{}
+		exps   []int64
+		add    = func(k skv.KvProgKey, v interface{}, exp int64) {
+			keys, values, exps = append(keys, k), append(values, v), append(exps, exp)
+		}
+	)
+
+	switch e.typ {
+
+	case "string":
+		if im.dry_run {
+			im.num["ok"]++
+			return
+		}
+		opts := &skv.KvWriteOptions{}
+		if e.expired > 0 {
+			opts.Ttl = e.expired - now
+		}
+		if rs := im.conn.KvPut(e.key, e.value, opts); !rs.OK() {
+			im.error(string(e.key), errors.New(rs.ErrorString()))
+		} else {
+			im.num["ok"]++
+		}
+		return
+
+	case "hash":
+		for i := 0; i+1 < len(e.fields); i += 2 {
+			exp := e.expired
+			if e.ttls != nil && e.ttls[i/2] > 0 {
+				if ttl := e.ttls[i/2]; ttl <= now {
+					im.num["skip_expired_field"]++
+					continue
+				} else if exp == 0 || ttl < exp {
+					exp = ttl
+				}
+			}
+			add(im.prog_key(e.key, e.fields[i]), e.fields[i+1], exp)
+		}
+
+	case "set":
+		if im.set_layout == "json" {
+			ls := []string{}
+			for _, v := range e.members {
+				ls = append(ls, string(v))
+			}
+			sort.Strings(ls)
+			add(im.prog_key(e.key), ls, e.expired)
+		} else {
+			for _, v := range e.members {
+				add(im.prog_key(e.key, v), v, e.expired)
+			}
+		}
+
+	case "zset":
+		switch im.zset_layout {
+		case "json":
+			ls := []zsetItem{}
+			for i, v := range e.members {
+				ls = append(ls, zsetItem{string(v), e.scores[i]})
+			}
+			add(im.prog_key(e.key), ls, e.expired)
+		case "score":
+			for i, v := range e.members {
+				add(im.prog_key(e.key, []byte(score_key(e.scores[i])), v), score_string(e.scores[i]), e.expired)
+			}
+		default:
+			for i, v := range e.members {
+				add(im.prog_key(e.key, v), score_string(e.scores[i]), e.expired)
+			}
+		}
+
+	default:
+		im.num["unmapped"]++
+		im.unmapped = append(im.unmapped, fmt.Sprintf("%s\t%q", e.typ, e.key))
+		return
+	}
+
+	for i, k := range keys {
+		if im.dry_run {
+			im.num["ok"]++
+			continue
+		}
+		opts := &skv.KvProgWriteOptions{}
+		if exps[i] > 0 {
+			opts.Expired = uint64(exps[i]) * 1e6
+		}
+		entry, err := lynkstor.NewCodecEntry(nil, values[i])
+		if err != nil {
+			im.error(prog_key_string(k), err)
+			continue
+		}
+		if rs := im.conn.KvProgPut(k, entry, opts); !rs.OK() {
+			im.error(prog_key_string(k), errors.New(rs.ErrorString()))
+		} else {
+			im.num["ok"]++
+		}
+	}
+}
+
+func (im *importer) error(key string, err error) {
+	im.num["error"]++
+	print_err(fmt.Errorf("%s: %s", key, err))
+}
+
+func (im *importer) report(elapsed time.Duration, list_unmapped bool) {
+
+	fmt.Println()
+	if im.dry_run {
+		fmt.Println("Summary (dry run, nothing written)")
+	} else {
+		fmt.Println("Summary")
+	}
+
+	names := []string{}
+	for k := range im.num {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Printf("  %-14s %d\n", k, im.num[k])
+	}
+	fmt.Printf("  elapsed        %s\n", elapsed.Round(time.Millisecond))
+
+	if list_unmapped && len(im.unmapped) > 0 {
+		fmt.Println("  unmapped keys:")
+		for i, v := range im.unmapped {
+			if i == 100 {
+				fmt.Printf("    ... %d more, use --unmapped to list all\n", len(im.unmapped)-i)
+				break
+			}
+			fmt.Println("    " + v)
+		}
+	}
+}
+
+func score_string(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// score_key encodes a score into 16 hex digits that sort in the order of
+// the scores.
+func score_key(score float64) string {
+	bits := math.Float64bits(score)
+	if score >= 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return fmt.Sprintf("%016x", bits)
+}
+
+func prog_key_string(k skv.KvProgKey) string {
+	s := ""
+	for _, v := range k.Items {
+		s += "/" + string(v.Data)
+	}
+	return s
+}
+
+func print_ok(msg string) {
+	fmt.Printf("\033[32m  %s \033[0m\n", msg)
+}
+
+func print_err(err error) {
+	fmt.Printf("\033[31m  %s \033[0m\n", err.Error())
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis serves the commands scan_import uses from fixed databases of
+// strings and hashes.
+type fakeRedis struct {
+	ln  net.Listener
+	dbs map[int]map[string]interface{}
+}
+
+func fake_redis(t *testing.T, dbs map[int]map[string]interface{}) *fakeRedis {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr := &fakeRedis{
+		ln:  ln,
+		dbs: dbs,
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	var (
+		r  = bufio.NewReader(conn)
+		db = 0
+	)
+
+	for {
+
+		args, err := fake_read(r)
+		if err != nil {
+			return
+		}
+
+		var (
+			cmd  = strings.ToUpper(args[0])
+			keys = fr.dbs[db]
+		)
+
+		switch cmd {
+
+		case "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			io.WriteString(conn, "+OK\r\n")
+
+		case "INFO":
+			s := "# Keyspace\r\n"
+			for n, ks := range fr.dbs {
+				s += fmt.Sprintf("db%d:keys=%d,expires=0\r\n", n, len(ks))
+			}
+			fake_bulk(conn, s)
+
+		case "SCAN":
+			ls := []string{}
+			for k := range keys {
+				if ok, _ := path.Match(args[3], k); ok {
+					ls = append(ls, k)
+				}
+			}
+			sort.Strings(ls)
+			fmt.Fprintf(conn, "*2\r\n")
+			fake_bulk(conn, "0")
+			fake_array(conn, ls)
+
+		case "TYPE":
+			switch keys[args[1]].(type) {
+			case string:
+				io.WriteString(conn, "+string\r\n")
+			case []string:
+				io.WriteString(conn, "+hash\r\n")
+			default:
+				io.WriteString(conn, "+none\r\n")
+			}
+
+		case "PTTL":
+			io.WriteString(conn, ":-1\r\n")
+
+		case "GET":
+			fake_bulk(conn, keys[args[1]].(string))
+
+		case "HGETALL":
+			fake_array(conn, keys[args[1]].([]string))
+
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func fake_read(r *bufio.Reader) ([]string, error) {
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for i := 0; i < n; i++ {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		bs := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		args = append(args, string(bs[:size]))
+	}
+
+	return args, nil
+}
+
+func fake_bulk(w io.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func fake_array(w io.Writer, ls []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(ls))
+	for _, v := range ls {
+		fake_bulk(w, v)
+	}
+}
+
+func TestScanImport(t *testing.T) {
+
+	fr := fake_redis(t, map[int]map[string]interface{}{
+		0: {
+			"a": "1",
+			"h": []string{"f1", "v1", "f2", "v2"},
+		},
+		3: {
+			"b": "2",
+		},
+	})
+	defer fr.ln.Close()
+
+	for _, v := range []struct {
+		db     int
+		string int
+		hash   int
+		ok     int
+	}{
+		{0, 1, 1, 3},
+		{3, 1, 0, 1},
+		{-1, 2, 1, 4},
+	} {
+
+		im := &importer{
+			match:   "*",
+			db:      v.db,
+			dry_run: true,
+			num:     map[string]int{},
+		}
+
+		if err := im.scan_import(fr.ln.Addr().String(), ""); err != nil {
+			t.Fatalf("db %d: %s", v.db, err)
+		}
+
+		if im.num["string"] != v.string || im.num["hash"] != v.hash || im.num["ok"] != v.ok {
+			t.Fatalf("db %d: %v", v.db, im.num)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	err_resp_parse = errors.New("resp: protocol error")
+)
+
+// respConn is a plain RESP client of the source server. The lynkstor
+// Connector is not used here because it decodes values by the lynkstor
+// value layout, while redis values are raw bytes.
+type respConn struct {
+	sock   net.Conn
+	reader *bufio.Reader
+}
+
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+func resp_dial(addr, auth string, db int) (*respConn, error) {
+
+	sock, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{
+		sock:   sock,
+		reader: bufio.NewReaderSize(sock, 64*1024),
+	}
+
+	if auth != "" {
+		if _, err := c.do("AUTH", auth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if db > 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends one command and returns its reply as string (simple strings),
+// []byte (bulk strings), int64, []interface{} or nil.
+func (c *respConn) do(args ...string) (interface{}, error) {
+
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, v := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(v))...)
+		buf = append(buf, v...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	c.sock.SetDeadline(time.Now().Add(60 * time.Second))
+	if _, err := c.sock.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *respConn) read() (interface{}, error) {
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, err_resp_parse
+	}
+	body := string(line[1 : len(line)-2])
+
+	switch line[0] {
+
+	case '+':
+		return body, nil
+
+	case '-':
+		return nil, respError(body)
+
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil || size < -1 {
+			return nil, err_resp_parse
+		}
+		if size == -1 {
+			return nil, nil
+		}
+		bs := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, bs); err != nil {
+			return nil, err
+		}
+		return bs[:size], nil
+
+	case '*':
+		size, err := strconv.Atoi(body)
+		if err != nil || size < -1 {
+			return nil, err_resp_parse
+		}
+		if size == -1 {
+			return nil, nil
+		}
+		ls := make([]interface{}, size)
+		for i := range ls {
+			if ls[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return ls, nil
+	}
+
+	return nil, err_resp_parse
+}
+
+func (c *respConn) Close() error {
+	return c.sock.Close()
+}
+
+func resp_bytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	}
+	return nil
+}
+
+func resp_list(v interface{}, err error) ([][]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok && v != nil {
+		return nil, err_resp_parse
+	}
+	ls := make([][]byte, len(items))
+	for i, item := range items {
+		ls[i] = resp_bytes(item)
+	}
+	return ls, nil
+}